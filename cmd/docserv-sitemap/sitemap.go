@@ -240,6 +240,16 @@ func walkDirs(dir string, baseURL string) error {
 		return fmt.Errorf("Reading %v failed: %v", dir, err)
 	}
 	for _, sfi := range suitedirs {
+		// The products are symlinks to their live generation,
+		// the generations and the staging directory are hidden.
+		if strings.HasPrefix(sfi.Name(), ".") {
+			continue
+		}
+		if sfi.Mode()&os.ModeSymlink != 0 {
+			if sfi, err = os.Stat(filepath.Join(dir, sfi.Name())); err != nil {
+				return err
+			}
+		}
 		if !sfi.IsDir() {
 			continue
 		}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thkukuk/rpm2docserv/pkg/bundled"
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+	"github.com/thkukuk/rpm2docserv/pkg/write"
+)
+
+var fullRebuild = flag.Bool("full-rebuild",
+	false,
+	"Ignore the build state of the last run and extract and render all manpages again")
+
+const buildStateFile = "buildstate.json"
+
+// buildStateFormat is incremented if the meaning of the stored data
+// changes, e.g. version 1 added the epoch to the package versions,
+// version 2 changed the directories of older versions (see
+// manpage.PkgMeta.DirVersion).
+const buildStateFormat = 2
+
+// buildState is stored in the serving directory and allows to only
+// extract and render what changed since the last run.
+type buildState struct {
+	// Version of rpm2docserv which wrote the state, any other
+	// version means a full rebuild.
+	Version string `json:"version"`
+	// Format of the state, see buildStateFormat
+	Format int `json:"format"`
+	// RawSuffix of the raw manpages, if it changes, all
+	// manpages need to be extracted again.
+	RawSuffix string `json:"rawsuffix"`
+	// Formats rendered besides HTML, if they change, all manpages
+	// are rendered again.
+	Formats  []string                 `json:"formats,omitempty"`
+	Products map[string]*productState `json:"products"`
+
+	mu sync.Mutex
+}
+
+type productState struct {
+	// Settings is a hash over the product configuration. If it
+	// changed, the product gets fully rebuild.
+	Settings string `json:"settings"`
+
+	// Generation is the generation of the product in the serving
+	// directory, which this state describes.
+	Generation string `json:"generation,omitempty"`
+
+	// RPMs is indexed by the path of the RPM
+	RPMs map[string]*rpmState `json:"rpms"`
+
+	// Pages is indexed by the path of the rendered page relative
+	// to the serving directory
+	Pages map[string]*pageState `json:"pages,omitempty"`
+}
+
+type rpmState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Checksum is only known for RPMs from rpm-md repositories
+	Checksum string `json:"checksum,omitempty"`
+
+	// The header data needed to create manpage.PkgMeta without
+	// reading the RPM again.
+	Name        string   `json:"name"`
+	Sourcepkg   string   `json:"sourcepkg"`
+	Version     string   `json:"version"`
+	Arch        string   `json:"arch,omitempty"`
+	ManpageList []string `json:"manpagelist,omitempty"`
+	// Alternatives are the manpages of ManpageList, which are
+	// installed with update-alternatives.
+	Alternatives []string `json:"alternatives,omitempty"`
+
+	// Manpages are the raw manpages in the serving directory
+	// (relative to it), which were extracted from this RPM.
+	Manpages []string `json:"manpages,omitempty"`
+
+	// Aliases of the extracted manpages, see manpage.PkgMeta
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// Problems found while extracting the manpages of this RPM
+	Problems []Problem `json:"problems,omitempty"`
+
+	// SignedBy is the key ID of the OpenPGP key with which the
+	// signature was verified.
+	SignedBy string `json:"signedby,omitempty"`
+	// Digest is the SHA-256 of the verified package file. The
+	// manpages are only extracted from exactly this file.
+	Digest string `json:"digest,omitempty"`
+}
+
+type pageState struct {
+	// Input is a hash over everything the rendered page depends on:
+	// the manpage, the templates, the other versions, sections and
+	// languages and the targets of all cross references.
+	Input string `json:"input"`
+
+	// Output is the hash of the rendered (uncompressed) HTML page.
+	Output string `json:"output"`
+
+	// Refs are all cross references (e.g. "ls(1)") found in the
+	// page during rendering.
+	Refs []string `json:"refs,omitempty"`
+
+	// Renderer is the program which converted the page to HTML,
+	// "mandoc" or "groff".
+	Renderer string `json:"renderer,omitempty"`
+
+	// Formats are the names of the formats rendered besides HTML.
+	Formats []string `json:"formats,omitempty"`
+}
+
+func newBuildState() *buildState {
+	return &buildState{
+		Version:   rpm2docservVersion,
+		Format:    buildStateFormat,
+		RawSuffix: manpage.RawSuffix,
+		Formats:   formatNames(),
+		Products:  make(map[string]*productState),
+	}
+}
+
+// loadBuildState reads the state of the last run. If there is none,
+// or it is not usable, an empty state is returned, which leads to a
+// full rebuild.
+func loadBuildState(servingDir string) *buildState {
+	state := newBuildState()
+	if *fullRebuild {
+		return state
+	}
+
+	b, err := os.ReadFile(filepath.Join(servingDir, buildStateFile))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Cannot read build state, doing a full rebuild: %v", err)
+		}
+		return state
+	}
+
+	var old buildState
+	if err := json.Unmarshal(b, &old); err != nil {
+		log.Printf("Cannot parse build state, doing a full rebuild: %v", err)
+		return state
+	}
+	if old.Version != rpm2docservVersion {
+		log.Printf("Build state written by rpm2docserv %s, doing a full rebuild", old.Version)
+		return state
+	}
+	if old.Format != buildStateFormat {
+		log.Printf("Build state has format %d instead of %d, doing a full rebuild", old.Format, buildStateFormat)
+		return state
+	}
+	if old.RawSuffix != manpage.RawSuffix {
+		log.Printf("Suffix of raw manpages changed, doing a full rebuild")
+		return state
+	}
+	if !slices.Equal(old.Formats, state.Formats) {
+		log.Printf("Output formats changed, doing a full rebuild")
+		return state
+	}
+	if old.Products == nil {
+		return state
+	}
+	return &old
+}
+
+func (s *buildState) save(servingDir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return write.Atomically(filepath.Join(servingDir, buildStateFile), false, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// product returns the state of product, creating it if necessary.
+func (s *buildState) product(name string) *productState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.Products[name]
+	if !ok {
+		ps = &productState{
+			RPMs:  make(map[string]*rpmState),
+			Pages: make(map[string]*pageState),
+		}
+		s.Products[name] = ps
+	}
+	if ps.Pages == nil {
+		ps.Pages = make(map[string]*pageState)
+	}
+	return ps
+}
+
+// lookup returns the state of the RPM path from the last run, if
+// the file was not modified since then.
+func (s *buildState) lookup(product string, path string, fi fs.FileInfo) *rpmState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.Products[product]
+	if !ok {
+		return nil
+	}
+	rs, ok := ps.RPMs[path]
+	if !ok || rs.Size != fi.Size() || !rs.ModTime.Equal(fi.ModTime()) {
+		return nil
+	}
+	return rs
+}
+
+// lookupChecksum returns the state of the RPM path from a rpm-md
+// repository from the last run, if its checksum did not change.
+func (s *buildState) lookupChecksum(product string, path string, checksum string) *rpmState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.Products[product]
+	if !ok {
+		return nil
+	}
+	rs, ok := ps.RPMs[path]
+	if !ok || checksum == "" || rs.Checksum != checksum {
+		return nil
+	}
+	return rs
+}
+
+// rpm returns the state of the RPM path.
+func (s *buildState) rpm(product string, path string) *rpmState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.Products[product]
+	if !ok {
+		return nil
+	}
+	return ps.RPMs[path]
+}
+
+func (s *buildState) setRPM(product string, path string, rs *rpmState) {
+	ps := s.product(product)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps.RPMs[path] = rs
+}
+
+func (s *buildState) deleteRPM(product string, path string) {
+	ps := s.product(product)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(ps.RPMs, path)
+}
+
+func (s *buildState) page(product string, dest string) *pageState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.Products[product]
+	if !ok {
+		return nil
+	}
+	return ps.Pages[dest]
+}
+
+func (s *buildState) setPage(product string, dest string, page *pageState) {
+	ps := s.product(product)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps.Pages[dest] = page
+}
+
+// productSettings returns a hash over the configuration of a product.
+func productSettings(product Product) string {
+	b, err := json.Marshal(product)
+	if err != nil {
+		// cannot happen, Product contains only plain types
+		log.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	templateHashOnce sync.Once
+	templateHash     string
+)
+
+// assetsHash returns a hash over all bundled (or injected) assets.
+func assetsHash() string {
+	templateHashOnce.Do(func() {
+		assets := bundled.AssetsFiltered(func(string) bool { return true })
+		names := make([]string, 0, len(assets))
+		for name := range assets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		h := sha256.New()
+		for _, name := range names {
+			fmt.Fprintf(h, "%s\x00%s\x00", name, assets[name])
+		}
+		templateHash = hex.EncodeToString(h.Sum(nil))
+	})
+	return templateHash
+}
+
+// pageInput calculates a hash over everything the rendered page of
+// job depends on, using refs as list of cross references.
+func pageInput(job renderJob, refs []string, gv *globalView) (string, error) {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%v\x00%v\x00",
+		rpm2docservVersion, assetsHash(), projectName, projectUrl,
+		logoUrl, isOffline, gv.productList)
+
+	f, err := os.Open(job.src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "\x00%d\x00", job.modTime.Unix())
+
+	for _, v := range job.versions {
+		fmt.Fprintf(h, "%s\x00%s\x00", v.ServingPath(), v.Package.Version.String())
+	}
+	for _, v := range job.older {
+		fmt.Fprintf(h, "%s\x00%s\x00", v.ServingPath(), v.Package.Version.String())
+	}
+	fmt.Fprintf(h, "%v\x00", job.meta.Package.Alternatives[job.meta.ServingPath()])
+	fmt.Fprintf(h, "%s\x00", job.meta.Package.Aliases[job.meta.ServingPath()])
+	fmt.Fprintf(h, "%v\x00", preferGroff(job.meta.Package))
+
+	resolve := xrefResolver(job)
+	for _, ref := range refs {
+		fmt.Fprintf(h, "%s\x00%s\x00", ref, resolve(ref))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removeFiles deletes files (relative to servingDir) from an older run.
+func removeFiles(servingDir string, files []string) {
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(servingDir, f)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Cannot remove %q: %v", f, err)
+		}
+	}
+}
+
+// relServingPath returns path relative to the serving directory.
+func relServingPath(path string) string {
+	rel, err := filepath.Rel(buildDir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// pageFiles returns all files of the rendered page: the HTML page
+// and its other formats.
+func pageFiles(page string) []string {
+	files := []string{page}
+	for _, f := range formats {
+		files = append(files, formatPath(page, f))
+	}
+	return files
+}
+
+// removeStalePages deletes all rendered pages of the last run, which
+// were not rendered or kept in this run.
+func removeStalePages(servingDir string, gv *globalView) {
+	for product, last := range gv.lastState.Products {
+		cur, ok := gv.state.Products[product]
+		if !ok {
+			continue
+		}
+		for page := range last.Pages {
+			if _, ok := cur.Pages[page]; !ok {
+				removeFiles(servingDir, pageFiles(page))
+			}
+		}
+	}
+}
+
+// removeStaleDirs deletes all package directories in productdir,
+// which are neither in pkgdirs, srcpkgdirs nor olderdirs.
+func removeStaleDirs(productdir string, pkgdirs map[string]bool, srcpkgdirs map[string]bool, olderdirs map[string]bool) {
+	entries, err := os.ReadDir(productdir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || pkgdirs[e.Name()] || srcpkgdirs[e.Name()] || olderdirs[e.Name()] {
+			continue
+		}
+		log.Printf("Removing stale directory %q", filepath.Join(productdir, e.Name()))
+		if err := os.RemoveAll(filepath.Join(productdir, e.Name())); err != nil {
+			log.Printf("Cannot remove %q: %v", e.Name(), err)
+		}
+	}
+}
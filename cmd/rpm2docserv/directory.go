@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+	"github.com/thkukuk/rpm2docserv/pkg/pkgsource"
+
+	"github.com/knqyf263/go-rpm-version"
+)
+
+// defaultDirectoryVersion is the version of the packages of a
+// directory source without a configured version.
+const defaultDirectoryVersion = "0"
+
+type mappingRule struct {
+	pattern string
+	pkg     string
+}
+
+// readMapping reads the mapping file of a directory source. Every
+// line contains a shell pattern and a package name, empty lines and
+// lines starting with "#" are ignored.
+func readMapping(fn string) ([]mappingRule, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []mappingRule
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<pattern> <package>\"", fn, lineno)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fn, lineno, err)
+		}
+		rules = append(rules, mappingRule{pattern: fields[0], pkg: fields[1]})
+	}
+	return rules, scanner.Err()
+}
+
+// directoryPackageOf returns a function mapping the paths relative to
+// the directory tree to package names. The first rule of the mapping
+// file matching the path or one of its directories wins, all other
+// manpages belong to the configured package.
+func directoryPackageOf(src *DirectorySource, prefix string, manpaths []string) (func(rel string) string, error) {
+	var rules []mappingRule
+	if src.Mapping != "" {
+		var err error
+		if rules, err = readMapping(src.Mapping); err != nil {
+			return nil, err
+		}
+	}
+	return func(rel string) string {
+		for _, r := range rules {
+			// a pattern matching a directory applies to all
+			// files below it
+			for p := rel; p != "."; p = path.Dir(p) {
+				if ok, _ := path.Match(r.pattern, p); ok {
+					return r.pkg
+				}
+			}
+		}
+		if src.Package == "" && isManpageName(path.Join(prefix, rel), manpaths) {
+			log.Printf("%s: no package for %q, skipping", src.Path, rel)
+		}
+		return src.Package
+	}, nil
+}
+
+// Read the directory tree of product and create a synthetic package
+// entry for every package name of the manpages in it. The manpages are
+// installed below the first manpath of the product and are copied from
+// the tree later.
+func scanDirectory(product Product, gv *globalView) ([]*manpage.PkgMeta, error) {
+	src := product.Directory
+	if src.Path == "" {
+		return nil, fmt.Errorf("no path configured")
+	}
+	if src.Package == "" && src.Mapping == "" {
+		return nil, fmt.Errorf("neither package nor mapping configured")
+	}
+	prefix := gv.manPaths[product.Name][0]
+	if strings.ContainsAny(prefix, "*?[") {
+		return nil, fmt.Errorf("the first manpath %q must not contain a pattern", prefix)
+	}
+	ver := src.Version
+	if ver == "" {
+		ver = defaultDirectoryVersion
+	}
+
+	packageOf, err := directoryPackageOf(src, prefix, gv.manPaths[product.Name])
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := pkgsource.ReadDirectory(src.Path, prefix, ver, packageOf)
+	if err != nil {
+		return nil, err
+	}
+	gv.stats.TotalNumberPkgs += uint64(len(pkgs))
+
+	var result []*manpage.PkgMeta
+	for _, p := range pkgs {
+		rs := &rpmState{
+			Checksum:    p.Digest,
+			Name:        p.Name,
+			Sourcepkg:   p.Source,
+			Version:     p.Version,
+			Arch:        p.Arch,
+			ManpageList: getManpageList(p.Files, gv.manPaths[product.Name]),
+		}
+		if len(rs.ManpageList) == 0 {
+			continue
+		}
+		if ok, err := checkSignature(product.Name, p.Path, rs, gv); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if old := gv.lastState.lookupChecksum(product.Name, p.Path, rs.Checksum); old != nil {
+			rs.Manpages = old.Manpages
+			rs.Aliases = old.Aliases
+			rs.Problems = old.Problems
+		}
+		gv.state.setRPM(product.Name, p.Path, rs)
+
+		pkg := new(manpage.PkgMeta)
+		pkg.Sourcepkg = rs.Sourcepkg
+		pkg.Product = product.Name
+		pkg.Filename = p.Path
+		pkg.ManpageList = rs.ManpageList
+		pkg.Binarypkg = rs.Name
+		pkg.Version = version.NewVersion(rs.Version)
+		pkg.Arch = rs.Arch
+		result = append(result, pkg)
+	}
+	return result, nil
+}
@@ -16,7 +16,6 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"os/exec"
 	"log"
 )
@@ -35,11 +34,11 @@ func executeCmd(command string, arg ...string) (error) {
 	log.Printf("Executing %s: %v", cmd.Path, cmd.Args)
 
 	if err = cmd.Run(); err != nil {
-		log.Printf("Error invoking " + command + ": " + fmt.Sprint(err) + "\n" + stderr.String())
+		log.Printf("Error invoking %s: %v\n%s", command, err, stderr.String())
 		return err
 	} else {
 		if *verbose {
-			log.Printf(out.String())
+			log.Print(out.String())
 		}
 	}
 
@@ -15,122 +15,99 @@
 package main
 
 import (
-	"bytes"
 	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"slices"
-	"sort"
 	"strings"
 	"sync/atomic"
 
+	"github.com/thkukuk/rpm2docserv/pkg/alternatives"
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
 	"github.com/thkukuk/rpm2docserv/pkg/manpage"
-	"github.com/thkukuk/rpm2docserv/pkg/rpm"
+	"github.com/thkukuk/rpm2docserv/pkg/pkgsource"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 type manLinks struct {
+	pkg *manpage.PkgMeta
 	binarypkg string
 	source string
+	// path of the manpage in the package
+	path string
 	target string
 	man *manpage.Meta
 	err error
+	// state of the resolution, see linkResolver
+	state int
+	resolveErr error
 }
 
+// Scripts which can install alternatives: the postinstall scriptlets
+// of RPMs and Debian packages
+var installScripts = map[string]bool{
+	"postinstall": true,
+	"posttrans":   true,
+	"postinst":    true,
+}
 
-var (
-	// list of packages, from which we know that the same
-	// file will be hardlinked several times and we can ignore them
-	// XXX should be part of a yaml config file...
-	// XXX define if prefix or whole string...
-	// this list must be sorted in ascending order!
-	// inn and mininews are conflicting packages build from the same source, so identical manpages
-	// python3* uses update-alternatives for the identical manual pages, only build for different python versions
-	extractErrorWhitelist = []string{"inn", "mininews", "python3"};
-	// qelectrotech ships french manual pages for different locales, we only need one
-	linkErrorWhitelist = []string{"qelectrotech", "wireless-tools"};
-)
+// packageAlternatives returns all alternatives installed by the scripts
+// of pkg.
+func packageAlternatives(pkg *pkgsource.Package) []alternatives.Alternative {
+	var alts []alternatives.Alternative
+	for _, s := range pkg.Scripts {
+		if installScripts[s.Name] {
+			alts = append(alts, alternatives.Parse(s.Body)...)
+		}
+	}
+	return alts
+}
 
-func isWhitelisted(pkg string, whitelist []string) (bool) {
+// sameManpage compares two paths of manual pages ignoring the
+// compression, the scripts often don't use the real suffix.
+func sameManpage(a, b string) bool {
+	return decompress.TrimSuffix(a) == decompress.TrimSuffix(b)
+}
 
-	// shorten package list to prefix in comparisation
-	i := sort.Search(len(whitelist), func(i int) bool {
-		if len(pkg) < len(whitelist[i]) {
-			return pkg <= whitelist[i]
-		} else {
-			return pkg[:len(whitelist[i])] <= whitelist[i]
+// manpageAlternatives returns the manual pages of manpageList, which
+// are links installed as alternative.
+func manpageAlternatives(alts []alternatives.Alternative, manpageList []string) []string {
+	var result []string
+	for _, f := range manpageList {
+		for _, a := range alts {
+			if _, ok := a.Lookup(func(link string) bool { return sameManpage(link, f) }); ok {
+				result = append(result, f)
+				break
+			}
 		}
-	})
-
-	if i < len(whitelist) && strings.HasPrefix(pkg, whitelist[i]) {
-		return true
-	} else {
-		return false
 	}
+	return result
 }
 
-// Parse RPM postinstall scripts for update-alternatives calls
-// and try to find out which manual page it points to by default
+// Parse the postinstall scripts of a package for update-alternatives
+// calls and find out which manual page it points to by default: the
+// one of the alternative with the highest priority.
 func getUpdateAlternatives(filename string, rpmfile string) (string, error) {
 
-	scripts, err := rpm.GetRPMScripts(rpmfile)
+	hdr, err := pkgsource.ReadPackage(rpmfile)
 	if err != nil {
-		return "", fmt.Errorf("rpm.GetRPMScripts(%s) failed: %v\n", rpmfile, err)
+		return "", fmt.Errorf("ReadPackage(%s) failed: %v\n", rpmfile, err)
 	}
 
-	found_ua := false
-	for i := range scripts {
-		if len(scripts[i]) == 0 {
-			continue
-		}
-		if scripts[i][0] == '#' {
-			continue
-		}
-		if strings.Index(scripts[i], "update-alternatives") < 0 && found_ua == false {
-			continue
-		} else {
-			found_ua = true
-		}
-		pos := strings.Index(scripts[i], filename)
-		if pos >= 0 {
-			str := scripts[i][pos+len(filename):]
-			if len(str) == 0 {
-				continue
-			}
-
-			// Remove all '"' around update-alternatives arguments
-			str = strings.Replace(str, "\"", "", -1)
-
-			// update-alternatives format normally is:
-			// string1 string2 string3, so in worst case:
-			// string1 \
-			// string2 \
-			// string3
-			// with this we should have all important entries
-			// in one line and can split them in words
-			if str[len(str)-1:] == "\\" {
-				str = str[:len(str)-1] + scripts[i+1]
-				if str[len(str)-1:] == "\\" {
-					str = str[:len(str)-1] + scripts[i+2]
-				}
-			}
-			words := strings.Fields(str)
-			if len(words) < 2 {
-				return "", fmt.Errorf("cannot parse update-alternatives entry for %q", filename)
-			}
-
-			return words[1], nil
-		}
-	}
-
-	return "", nil
+	target, _ := alternatives.Resolve(packageAlternatives(hdr), func(link string) bool {
+		return sameManpage(link, filename)
+	})
+	return target, nil
 }
 
-func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
+func getManpageRef(f string, tmpdir string, rpmfile string, manpaths []string) (string, error) {
 
 	// check if the source file (manual page) is a symlink. If yes, hardlink the
 	// file the symlink points to as target file with the old name
@@ -142,9 +119,10 @@ func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
 			if err != nil {
 				return f, err
 			} else if len(dstf) == 0 {
-				return f, fmt.Errorf("%q not found on disk and in RPM scripts", strings.TrimPrefix(f, tmpdir))
+				return f, &refError{problemMissingTarget,
+					fmt.Errorf("%q not found on disk and in RPM scripts", strings.TrimPrefix(f, tmpdir))}
 			}
-			return getManpageRef(filepath.Join(tmpdir, dstf), tmpdir, rpmfile)
+			return getManpageRef(filepath.Join(tmpdir, dstf), tmpdir, rpmfile, manpaths)
 		} else {
 			return f, err
 		}
@@ -157,7 +135,7 @@ func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
 		// does not point outside our tmpdir
 		if len(symlink) > 0 && strings.HasPrefix(symlink, tmpdir) {
 			// could point to another link or be a .so reference
-			return getManpageRef(symlink, tmpdir, rpmfile)
+			return getManpageRef(symlink, tmpdir, rpmfile, manpaths)
 		} else {
 			// Most likely the source file is in another RPM or update-alternatives,
 			link, err := os.Readlink(f)
@@ -170,15 +148,17 @@ func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
 				if err != nil {
 					return f, err
 				} else if len(dstf) == 0 {
-					return f, fmt.Errorf("%q (update-alternative) not found in RPM scripts", strings.TrimPrefix(f, tmpdir))
+					return f, &refError{problemMissingTarget,
+						fmt.Errorf("%q (update-alternative) not found in RPM scripts", strings.TrimPrefix(f, tmpdir))}
 				}
-				return getManpageRef(filepath.Join(tmpdir, dstf), tmpdir, rpmfile)
+				return getManpageRef(filepath.Join(tmpdir, dstf), tmpdir, rpmfile, manpaths)
 			} else {
-				dstf := link
-				if link[:0] != "/" {
+				dstf := filepath.Join(tmpdir, link)
+				if !filepath.IsAbs(link) {
 					dstf = filepath.Join(filepath.Dir(f), link)
 				}
-				return dstf, fmt.Errorf("Dangling symlink: %q -> %q", f, dstf)
+				return dstf, &refError{problemDanglingSymlink,
+					fmt.Errorf("Dangling symlink: %q -> %q", strings.TrimPrefix(f, tmpdir), strings.TrimPrefix(dstf, tmpdir))}
 			}
 		}
 	}
@@ -186,26 +166,20 @@ func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
 	// Handle .so links. Don't open, read and decompress all manpages,
 	// the filesize should be smaller than 200 byte if it is only a .so link
 	if fileInfo.Size() < 200 {
-		// Open the gzip file.
-		fh, err := os.Open(f)
+		// Open the manpage, it can be compressed with any method
+		reader, err := decompress.Open(f)
 		if err != nil {
 			return "", fmt.Errorf("Error opening file %q: %v", f, err)
 		}
-		// Create new reader to decompress gzip.
-		reader, err := gzip.NewReader(fh)
-		if err != nil {
-			fh.Close()
-			return "", fmt.Errorf("Error creating gzip.NewReader: %v", err)
-		}
 
 		// Empty byte slice.
 		result := make([]byte, 300)
 
 		// Read in data.
-		count, err := reader.Read(result)
-		fh.Close()
-		if err != nil && err != io.EOF {
-			return "", fmt.Errorf("Error opening gzip compress file %q: %v", f, err)
+		count, err := io.ReadFull(reader, result)
+		reader.Close()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", fmt.Errorf("Error reading compressed file %q: %v", f, err)
 		}
 
 		str := string(result)[:count]
@@ -225,6 +199,7 @@ func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
 			var section string
 
 			str = strings.TrimPrefix(str, ".so ")
+			ref := str
 
 			pos := strings.Index(str, "/")
 			if pos > 0 {
@@ -240,32 +215,178 @@ func getManpageRef(f string, tmpdir string, rpmfile string) (string, error) {
 			prefix := filepath.Dir(f)
 			prefix = filepath.Dir(prefix)
 
-			soRef := filepath.Join(prefix, section, str)
 			// some .so references include .gz, others not
-			// (e.g. regulartory.db.5.gz from wireless-regdb)
-			if !strings.HasSuffix(soRef, ".gz") {
-				soRef = soRef + ".gz"
+			// (e.g. regulartory.db.5.gz from wireless-regdb),
+			// and the referenced manpage can be compressed with
+			// another method than the manpage itself.
+			soRef := findManpage(decompress.TrimSuffix(filepath.Join(prefix, section, str)),
+				decompress.Suffix(decompress.FromFilename(f)))
+
+			// The .so reference must stay within the root in
+			// which the manpage was found
+			if root, _, ok := manRoot(manpaths, strings.TrimPrefix(f, tmpdir)); ok &&
+				!strings.HasPrefix(strings.TrimPrefix(soRef, tmpdir), root+"/") {
+				return "", &refError{problemOutsideRoot,
+					fmt.Errorf(".so reference %q of %q points outside of %s", ref, strings.TrimPrefix(f, tmpdir), root)}
 			}
 
 			// Check that the .so reference does not point to itself
 			// See [bsc#1202943] as example
 			if f == soRef {
-				log.Printf("WARNING: %q points to itself!\n", soRef)
-				return soRef, nil
+				return soRef, &refError{problemSelfReference,
+					fmt.Errorf(".so reference %q points to itself", ref)}
 			} else {
-				return getManpageRef(soRef, tmpdir, rpmfile)
+				return getManpageRef(soRef, tmpdir, rpmfile, manpaths)
 			}
 		}
 	}
 	return f, nil
 }
 
+// Search the manpage base with any compression suffix. If there is
+// none, base with suffix is returned.
+func findManpage(base string, suffix string) string {
+	for _, method := range []string{decompress.Gzip, decompress.Zstd, decompress.Xz,
+		decompress.Bzip2, decompress.Lzma, decompress.None} {
+		fn := base + decompress.Suffix(method)
+		if _, err := os.Lstat(fn); err == nil {
+			return fn
+		}
+	}
+	return base + suffix
+}
+
+// compressWriter returns a writer compressing with method to w.
+func compressWriter(w io.Writer, method string) (io.WriteCloser, error) {
+	switch method {
+	case decompress.Gzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case decompress.Xz:
+		return xz.NewWriter(w)
+	case decompress.Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	}
+	return nil, fmt.Errorf("unsupported compression method %q", method)
+}
+
+// Install the manpage srcf as dstf in the serving directory. If it is
+// already compressed with the method of the raw manpages, a hardlink
+// is created, else it gets recompressed.
+func installManpage(srcf string, dstf string) (err error) {
+	f, err := os.Open(srcf)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, method, err := decompress.Detect(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	want := decompress.FromFilename(manpage.RawSuffix)
+	if method == want {
+		return os.Link(srcf, dstf)
+	}
+
+	out, err := os.OpenFile(dstf, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dstf)
+		}
+	}()
+
+	zw, err := compressWriter(out, want)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(zw, zr); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Collect all files below the manpaths and the targets of symlinks
+// and hardlinks pointing from there to other places in the package.
+func wantedFiles(files []pkgsource.File, manpaths []string) map[string]bool {
+	wanted := make(map[string]bool)
+	byName := make(map[string]*pkgsource.File, len(files))
+	for i := range files {
+		byName[files[i].Name] = &files[i]
+	}
+
+	for _, f := range files {
+		if _, _, ok := manRoot(manpaths, f.Name); !ok {
+			continue
+		}
+		wanted[f.Name] = true
+
+		// follow the symlink chain, but not endless
+		for link := &f; link != nil && link.Linkto != ""; {
+			target := link.Linkto
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(link.Name), target)
+			}
+			if wanted[target] {
+				break
+			}
+			wanted[target] = true
+			link = byName[target]
+		}
+	}
+	return wanted
+}
+
+// Extract the manual pages and the files they link to from the
+// package fn into destDir. If digest is set, the signature of the
+// package was verified and it is only extracted if it did not change.
+func extractPackage(fn string, destDir string, manpaths []string, digest string) error {
+	src := pkgsource.ForFile(fn)
+	if src == nil {
+		return fmt.Errorf("unsupported package format")
+	}
+
+	hdr, err := src.ReadPackage(fn)
+	if err != nil {
+		return err
+	}
+
+	wanted := wantedFiles(hdr.Files, manpaths)
+	want := func(name string) bool {
+		return wanted[name]
+	}
+	if digest != "" {
+		return extractVerified(fn, destDir, want, digest)
+	}
+	return src.Extract(fn, destDir, want)
+}
+
+// sourceDir returns the directory below tmpdir in which the manpages
+// of the source package of pkg are collected. Older versions kept
+// because of keep_versions get their own one.
+func sourceDir(tmpdir string, pkg *manpage.PkgMeta) string {
+	if pkg.Older {
+		return filepath.Join(tmpdir, pkg.Sourcepkg+"@"+pkg.DirVersion())
+	}
+	return filepath.Join(tmpdir, pkg.Sourcepkg)
+}
+
 // Unpack a RPM, copy the manual pages in a separate directory together with all other
 // manualpages of the source RPM
 // We need directories per source RPM to be able to extract conflicting packages.
 // We need all manpages from all subpackages of a Source RPM since symlinks and .so
 // references are going cross packages.
-func unpackRPMs(cacheDir string, tmpdir string, product string, gv *globalView) (error) {
+func unpackRPMs(cacheDir string, tmpdir string, product string, dirty map[string]bool, gv *globalView) (error) {
 
 	for i := range gv.pkgs {
 		if gv.pkgs[i].Product != product {
@@ -274,6 +395,9 @@ func unpackRPMs(cacheDir string, tmpdir string, product string, gv *globalView)
 		if len(gv.pkgs[i].ManpageList) == 0 {
 			continue
 		}
+		if dirty != nil && !dirty[gv.pkgs[i].Sourcepkg] {
+			continue
+		}
 
 		unrpmDir := filepath.Join(tmpdir, "unrpm")
 		err := os.MkdirAll(unrpmDir, 0755)
@@ -282,42 +406,21 @@ func unpackRPMs(cacheDir string, tmpdir string, product string, gv *globalView)
 			return fmt.Errorf("Cannot create directoy %q: %v", unrpmDir, err)
 		}
 
-		// XXX rpm packge als rpm.Unpack
-		rpm2cpio := exec.Command("rpm2cpio", gv.pkgs[i].Filename)
-		cpio := exec.Command("cpio", "-D", unrpmDir,
-			"--extract",
-			"--unconditional",
-			"--preserve-modification-time",
-			"--no-preserve-owner",
-			"--make-directories")
-
-		cpio.Stdin, _ = rpm2cpio.StdoutPipe()
-		cpio.Stdout = os.Stdout
-		var stderrb bytes.Buffer
-		cpio.Stderr = &stderrb
-		err = cpio.Start()
-		if err != nil {
-			return fmt.Errorf("Error invoking cpio (%s): %v, stderr: %s",  filepath.Base(gv.pkgs[i].Filename), err, stderrb.String())
-		}
-		err = rpm2cpio.Run()
-		if err != nil {
-			return fmt.Errorf("Error invoking rpm2cpio: %v", err)
+		var digest string
+		if rs := gv.state.rpm(product, gv.pkgs[i].Filename); rs != nil {
+			digest = rs.Digest
 		}
-		err = cpio.Wait()
+		err = extractPackage(gv.pkgs[i].Filename, unrpmDir, gv.manPaths[product], digest)
 		if err != nil {
-			return fmt.Errorf("Error waiting for cpio (%s): %v, stderr: %s", filepath.Base(gv.pkgs[i].Filename), err, stderrb.String())
-		}
-
-		// If run as non root, make all files read-/writable for the user.
-		if os.Getuid() != 0 {
-			chmod := exec.Command("chmod", "u+rw", "-R", unrpmDir)
-			if err = chmod.Run(); err != nil {
-				return fmt.Errorf("Error setting u+w permissions: %v", err)
-			}
+			os.RemoveAll(unrpmDir)
+			gv.errors.add(product, errorExtract, gv.pkgs[i].Filename,
+				fmt.Errorf("Error extracting %s: %v", filepath.Base(gv.pkgs[i].Filename), err))
+			skipPackage(gv.pkgs[i], gv)
+			continue
 		}
 
 		for _, f := range gv.pkgs[i].ManpageList {
-			dstf := filepath.Join(tmpdir, gv.pkgs[i].Sourcepkg, f)
+			dstf := filepath.Join(sourceDir(tmpdir, gv.pkgs[i]), f)
 
 			err = os.MkdirAll(filepath.Dir(dstf), 0755)
 			if err != nil {
@@ -339,8 +442,13 @@ func unpackRPMs(cacheDir string, tmpdir string, product string, gv *globalView)
 			}
 
 			err = os.Link(srcf, dstf)
-			if err != nil && !errors.Is(err, os.ErrNotExist) && !isWhitelisted(gv.pkgs[i].Binarypkg, extractErrorWhitelist) {
-				log.Printf("Cannot hardlink %q (%s): %v", srcf, gv.pkgs[i].Binarypkg, err)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				resolution := resolutionSkipped
+				if ignores.ignored(extractErrors, product, gv.pkgs[i].Binarypkg) {
+					resolution = resolutionIgnored
+				}
+				addProblem(gv, gv.pkgs[i], f, problemExtract,
+					fmt.Sprintf("Cannot hardlink: %v", err), resolution)
 				continue
 			}
 		}
@@ -350,17 +458,160 @@ func unpackRPMs(cacheDir string, tmpdir string, product string, gv *globalView)
 	return nil
 }
 
-func extractManpages(cacheDir string, servingDir string, product string, gv *globalView) (error) {
+// skipPackage drops all manpages of pkg, which could not be extracted.
+// The package is forgotten in the build state, so that the next run
+// tries again.
+func skipPackage(pkg *manpage.PkgMeta, gv *globalView) {
+	for _, f := range pkg.ManpageList {
+		if m, err := manpageFromPath(gv.manPaths[pkg.Product], f, nil); err == nil {
+			deleteXref(pkg, m, gv)
+		}
+	}
+	pkg.ManpageList = nil
+	gv.state.deleteRPM(pkg.Product, pkg.Filename)
+}
+
+// deleteXref removes the entry for m of pkg from the cross reference
+// index (or the older versions), used if the manual page could not be
+// extracted.
+func deleteXref(pkg *manpage.PkgMeta, m *manpage.Meta, gv *globalView) {
+	if pkg.Older {
+		x := gv.older[m.Name]
+		for j := range x {
+			if pkg == x[j].Package && m.Section == x[j].Section && m.Language == x[j].Language {
+				gv.older[m.Name] = slices.Delete(x, j, j+1)
+				break
+			}
+		}
+		return
+	}
+
+	x := gv.xref[m.Name]
+	for j := range x {
+		if pkg.Product == x[j].Package.Product && m.Section == x[j].Section && m.Language == x[j].Language {
+			log.Printf("Deleting entry: %q", gv.xref[m.Name][j])
+			gv.xref[m.Name] = slices.Delete(gv.xref[m.Name], j, j+1)
+			break
+		}
+	}
+}
+
+// keepManpages handles a package, which did not change since the last
+// run and whose manual pages are therefore still in servingDir.
+func keepManpages(servingDir string, pkg *manpage.PkgMeta, gv *globalView) {
+	if rs := gv.state.product(pkg.Product).RPMs[pkg.Filename]; rs != nil {
+		pkg.Aliases = rs.Aliases
+	}
+	for _, f := range pkg.ManpageList {
+		m, err := manpageFromPath(gv.manPaths[pkg.Product], f, nil)
+		if err != nil {
+			continue
+		}
+		dstf := filepath.Join(servingDir, pkg.Product, pkg.Dir(), m.Name+"."+m.Section+"."+m.Language+manpage.RawSuffix)
+		if _, err := os.Lstat(dstf); err != nil {
+			// could not be extracted in the last run, too
+			deleteXref(pkg, m, gv)
+		}
+	}
+}
+
+// changedSources compares the RPMs of product with the ones of the
+// last run and returns the source packages, which need to be
+// extracted again. The manual pages extracted from them in the last
+// run are removed. If nil is returned, everything needs to be
+// extracted.
+func changedSources(servingDir string, product string, gv *globalView) map[string]bool {
+	last, ok := gv.lastState.Products[product]
+	if !ok {
+		return nil
+	}
+	cur := gv.state.product(product)
+
+	dirty := make(map[string]bool)
+	for path, rs := range cur.RPMs {
+		old, ok := last.RPMs[path]
+		if ok && old.Size == rs.Size && old.ModTime.Equal(rs.ModTime) && old.Checksum == rs.Checksum {
+			continue
+		}
+		dirty[rs.Sourcepkg] = true
+		if ok {
+			dirty[old.Sourcepkg] = true
+		}
+	}
+	for path, old := range last.RPMs {
+		if _, ok := cur.RPMs[path]; !ok {
+			dirty[old.Sourcepkg] = true
+		}
+	}
+
+	// Links into other source packages are hardlinks of their
+	// target, they need to be extracted again if the target changed.
+	sources := make(map[string]string)
+	for _, rs := range cur.RPMs {
+		sources[rs.Name] = rs.Sourcepkg
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, rs := range cur.RPMs {
+			if dirty[rs.Sourcepkg] {
+				continue
+			}
+			for _, target := range rs.Aliases {
+				// target is <product>/<package>/<manpage>
+				parts := strings.SplitN(target, "/", 3)
+				if len(parts) != 3 {
+					continue
+				}
+				src, ok := sources[parts[1]]
+				if !ok || dirty[src] {
+					dirty[rs.Sourcepkg] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	for _, old := range last.RPMs {
+		if dirty[old.Sourcepkg] {
+			removeFiles(servingDir, old.Manpages)
+		}
+	}
+	for _, rs := range cur.RPMs {
+		if dirty[rs.Sourcepkg] {
+			rs.Manpages = nil
+			rs.Aliases = nil
+			rs.Problems = nil
+		}
+	}
+
+	return dirty
+}
+
+// Remember that dstf was extracted from pkg
+func recordManpage(servingDir string, dstf string, pkg *manpage.PkgMeta, gv *globalView) {
+	rs := gv.state.product(pkg.Product).RPMs[pkg.Filename]
+	if rs == nil {
+		return
+	}
+	rel, err := filepath.Rel(servingDir, dstf)
+	if err != nil {
+		return
+	}
+	rs.Manpages = append(rs.Manpages, rel)
+}
+
+func extractManpages(cacheDir string, servingDir string, product string, dirty map[string]bool, gv *globalView) (error) {
 
 	var missing []*manLinks
 
 	tmpdir, err := os.MkdirTemp(servingDir, "collect-")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer os.RemoveAll(tmpdir)
 
-	err = unpackRPMs(cacheDir, tmpdir, product, gv)
+	err = unpackRPMs(cacheDir, tmpdir, product, dirty, gv)
 	if err != nil {
 		return err
 	}
@@ -372,128 +623,90 @@ func extractManpages(cacheDir string, servingDir string, product string, gv *glo
 		if len(gv.pkgs[i].ManpageList) == 0 {
 			continue
 		}
+		if dirty != nil && !dirty[gv.pkgs[i].Sourcepkg] {
+			keepManpages(servingDir, gv.pkgs[i], gv)
+			atomic.AddUint64(&gv.stats.PackagesExtracted, 1)
+			continue
+		}
 
 		for _, f := range gv.pkgs[i].ManpageList {
-			m, err :=  manpage.FromManPath(strings.TrimPrefix(f, manPrefix), nil)
+			m, err :=  manpageFromPath(gv.manPaths[product], f, nil)
 			if err != nil {
 				// not well formated manual page, already reported, ignore it
 				continue
 			}
 
-			targetdir := filepath.Join(servingDir, gv.pkgs[i].Product, gv.pkgs[i].Binarypkg)
+			targetdir := filepath.Join(servingDir, gv.pkgs[i].Product, gv.pkgs[i].Dir())
 
 			err = os.MkdirAll(targetdir, 0755)
 			if err != nil {
 				return fmt.Errorf("Cannot create target dir %q: %v", targetdir, err)
 			}
 
-			dstf := filepath.Join(targetdir, m.Name + "." + m.Section + "." + m.Language + ".gz")
+			dstf := filepath.Join(targetdir, m.Name + "." + m.Section + "." + m.Language + manpage.RawSuffix)
 
-			srcf, err := getManpageRef(filepath.Join(tmpdir, gv.pkgs[i].Sourcepkg, f), filepath.Join(tmpdir, gv.pkgs[i].Sourcepkg), gv.pkgs[i].Filename)
+			srcDir := sourceDir(tmpdir, gv.pkgs[i])
+			srcf, err := getManpageRef(filepath.Join(srcDir, f), srcDir, gv.pkgs[i].Filename, gv.manPaths[product])
+			if problemKind(err) == problemSelfReference {
+				// See [bsc#1202943] as example
+				addProblem(gv, gv.pkgs[i], f, problemSelfReference, err.Error(), resolutionKept)
+				err = nil
+			}
 			if err != nil {
 				if len(srcf) > 0 {
 					missing = append (missing, &manLinks{
+						pkg: gv.pkgs[i],
 						binarypkg: gv.pkgs[i].Binarypkg,
-						source: strings.TrimPrefix(srcf, filepath.Join(tmpdir, gv.pkgs[i].Sourcepkg)),
+						source: strings.TrimPrefix(srcf, srcDir),
+						path: f,
 						target: dstf,
 						man: m,
 						err: err,
 					})
 				} else {
-					x := gv.xref[m.Name]
-					for j := range x {
-						if product == x[j].Package.Product && m.Section == x[j].Section && m.Language == x[j].Language {
-							log.Printf("Deleting entry: %q", gv.xref[m.Name][j])
-							gv.xref[m.Name] = slices.Delete(gv.xref[m.Name], j, j+1)
-							break
-						}
-					}
-					log.Printf("Error in finding manpage (%s/%s): %v", product, gv.pkgs[i].Binarypkg, err)
+					deleteXref(gv.pkgs[i], m, gv)
+					addProblem(gv, gv.pkgs[i], f, problemKind(err), err.Error(), resolutionRemoved)
 				}
 				continue
 			}
 
-			err = os.Link(srcf, dstf)
-			if err != nil && !isWhitelisted(gv.pkgs[i].Binarypkg, linkErrorWhitelist){
-				log.Printf("Cannot hardlink %q (%s/%s): %v", srcf, product, gv.pkgs[i].Binarypkg, err)
+			err = installManpage(srcf, dstf)
+			if err != nil {
+				resolution := resolutionSkipped
+				if ignores.ignored(linkErrors, product, gv.pkgs[i].Binarypkg) {
+					resolution = resolutionIgnored
+				}
+				addProblem(gv, gv.pkgs[i], f, problemInstall, err.Error(), resolution)
 				continue
 			}
+			recordManpage(servingDir, dstf, gv.pkgs[i], gv)
 		}
 
 		atomic.AddUint64(&gv.stats.PackagesExtracted, 1)
 	}
 
+	// Manpages pointing to files which are not part of the source
+	// package, find them in other packages.
+	r := newLinkResolver(servingDir, product, missing, gv)
 	for i := range missing {
-		todelete := -1
-
-                m, err :=  manpage.FromManPath(strings.TrimPrefix(missing[i].source, manPrefix), nil)
-                if err != nil {
-			log.Printf("Error with missing manpage (%s/%s): %v", product, missing[i].binarypkg, err)
-			continue
-		}
-
-                found := false
-                x := gv.xref[m.Name]
-                for j := range x {
-                        if product == x[j].Package.Product && m.Section == x[j].Section && m.Language == x[j].Language {
-                                srcf := filepath.Join(servingDir, x[j].ServingPath() + ".gz")
-                                err = os.Link(srcf, missing[i].target)
-                                if err != nil {
-					todelete = j
-                                        continue
-                                }
-                                found = true
-                                break
-                        }
-                }
-
-		// second run, relax m.Section and also allow substring matches (e.g. postgresql14-docs, where the .so reference got not adjusted
-		if !found {
-			for j := range x {
-				if product == x[j].Package.Product && strings.HasPrefix(x[j].Section, m.Section) && m.Language == x[j].Language {
-					srcf := filepath.Join(servingDir, x[j].ServingPath() + ".gz")
-					err = os.Link(srcf, missing[i].target)
-					if err != nil {
-						continue
-					}
-					found = true
-					break
-				}
-			}
-		}
-		// No we really didn't found it.
-                if !found {
-			log.Printf("Manpage (%s/%s) not found: %v", product, missing[i].binarypkg, missing[i].err)
-			if todelete >= 0 {
-				log.Printf("Deleting entry: %q", gv.xref[m.Name][todelete])
-				gv.xref[m.Name] = slices.Delete(gv.xref[m.Name], todelete, todelete+1)
-			} else {
-				m := missing[i].man
-				x := gv.xref[m.Name]
-				for j := range x {
-					if product == x[j].Package.Product && m.Section == x[j].Section && m.Language == x[j].Language {
-						log.Printf("Deleting entry: %q", gv.xref[m.Name][j])
-						gv.xref[m.Name] = slices.Delete(gv.xref[m.Name], j, j+1)
-						break
-					}
-				}
-
-			}
-
-                        continue
-                }
-        }
+		r.resolve(missing[i], 0)
+	}
 
 	return nil
 }
 
 func extractManpagesAll(cacheDir string, servingDir string, gv *globalView) (error) {
 	for product := range gv.products {
-		// Cleanup directory for product
-		productdir := filepath.Join(servingDir, product)
-		os.RemoveAll(productdir)
+		dirty := changedSources(servingDir, product, gv)
+		if dirty == nil {
+			// Cleanup directory for product
+			productdir := filepath.Join(servingDir, product)
+			os.RemoveAll(productdir)
+		} else {
+			log.Printf("Extracting %d changed source packages of %s", len(dirty), product)
+		}
 
-		err := extractManpages(cacheDir, servingDir, product, gv)
+		err := extractManpages(cacheDir, servingDir, product, dirty, gv)
 		if err != nil {
 			return err
 		}
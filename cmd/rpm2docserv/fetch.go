@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/thkukuk/rpm2docserv/pkg/rpmmd"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var downloadConcurrency = flag.Int("concurrency_download",
+	4,
+	"Number of parallel downloads from rpm-md repositories")
+
+func hasRemoteRepos(products []Product) bool {
+	for _, product := range products {
+		for _, baseurl := range product.Repos {
+			if rpmmd.IsRemote(baseurl) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// productCache returns the directory in which the downloaded RPMs of
+// product are stored.
+func productCache(product Product) string {
+	if len(product.Cache) > 0 {
+		return product.Cache[0]
+	}
+	return filepath.Join(*cacheDir, product.Name)
+}
+
+// repoMirror returns the directory in which the metadata and the
+// RPMs with manual pages of the remote repository baseurl are stored.
+// The directory itself is a valid rpm-md repository.
+func repoMirror(product Product, baseurl string) string {
+	name := baseurl
+	if idx := strings.Index(name, "://"); idx > -1 {
+		name = name[idx+3:]
+	}
+	name = strings.Trim(name, "/")
+	name = strings.NewReplacer("/", "_", ":", "_").Replace(name)
+	return filepath.Join(productCache(product), "repos", name)
+}
+
+// Download all packages with manual pages of a remote repository
+// into its mirror directory. Packages already present with the
+// correct checksum are not downloaded again, packages no longer in
+// the repository are removed. A package which cannot be downloaded
+// is removed, too, and counted as error of product.
+func fetchRepo(product Product, baseurl string, errs *runErrors) error {
+	repo, err := rpmmd.Open(baseurl)
+	if err != nil {
+		return err
+	}
+	pkgs, err := repo.Packages(func(p *rpmmd.Package) bool {
+		return repoHasManpages(p, productManPaths(product))
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := repoMirror(product, baseurl)
+	wanted := make(map[string]bool, len(pkgs))
+	for _, p := range pkgs {
+		wanted[filepath.Join(dir, filepath.FromSlash(path.Clean("/"+p.Location)))] = true
+	}
+
+	var downloaded, valid, failed atomic.Int64
+	var eg errgroup.Group
+	eg.SetLimit(*downloadConcurrency)
+	for _, p := range pkgs {
+		eg.Go(func() error {
+			dest := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+p.Location)))
+			if rpmmd.VerifyFile(dest, p.ChecksumType, p.Checksum) == nil {
+				valid.Add(1)
+				return nil
+			}
+			if *verbose {
+				log.Printf("Downloading %s", p.Location)
+			}
+			if err := repo.Download(p, dest); err != nil {
+				// an old version of the package must not be
+				// used with the new metadata
+				if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+					log.Printf("Cannot remove %q: %v", dest, err)
+				}
+				errs.add(product.Name, errorDownload, p.Location, fmt.Errorf("cannot download %s: %v", p.Location, err))
+				failed.Add(1)
+				return nil
+			}
+			downloaded.Add(1)
+			return nil
+		})
+	}
+	eg.Wait()
+
+	// Remove RPMs which are no longer part of the repository
+	err = filepath.WalkDir(dir, func(path string, di fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".rpm") && !wanted[path] {
+			if *verbose {
+				log.Printf("Removing %q", path)
+			}
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := repo.SaveMetadata(dir); err != nil {
+		return err
+	}
+
+	log.Printf("Repository %s: %d packages with manpages, %d downloaded, %d up to date, %d failed",
+		baseurl, len(pkgs), downloaded.Load(), valid.Load(), failed.Load())
+	return nil
+}
+
+// Download the packages with manual pages of all remote rpm-md
+// repositories of all products.
+func fetchRepos(products []Product, errs *runErrors) error {
+	for _, product := range products {
+		for _, baseurl := range product.Repos {
+			if !rpmmd.IsRemote(baseurl) {
+				continue
+			}
+			log.Printf("Fetching %s for %s...", baseurl, product.Name)
+			if err := fetchRepo(product, baseurl, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/thkukuk/rpm2docserv/pkg/convert"
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+	"github.com/thkukuk/rpm2docserv/pkg/write"
+)
+
+// outputFormat is a format the manpages are rendered to besides HTML.
+// The file is stored compressed next to the HTML page, e.g.
+// ls.1.en.pdf.gz next to ls.1.en.html.gz.
+type outputFormat struct {
+	// Name in the config and the metrics
+	Name string
+	// Title of the link on the manpage
+	Title  string
+	Suffix string
+
+	convert func(r io.Reader) (string, error)
+}
+
+// textFormat is always rendered, see manpage.TextSuffix.
+var textFormat = &outputFormat{
+	Name:    "txt",
+	Title:   "plain text",
+	Suffix:  manpage.TextSuffix,
+	convert: convert.ToText,
+}
+
+// optionalFormats can be selected with the "formats" option.
+var optionalFormats = []*outputFormat{
+	{
+		Name:    "pdf",
+		Title:   "PDF",
+		Suffix:  ".pdf",
+		convert: convert.ToPDF,
+	},
+	{
+		Name:    "markdown",
+		Title:   "Markdown",
+		Suffix:  ".md",
+		convert: convert.ToMarkdown,
+	},
+}
+
+// formats are all formats rendered besides HTML
+var formats = []*outputFormat{textFormat}
+
+// setupFormats selects the optional formats of the config.
+func setupFormats(config Config) error {
+	var extraFormats []*outputFormat
+	for _, name := range config.Formats {
+		if name == textFormat.Name {
+			// always rendered
+			continue
+		}
+		var found *outputFormat
+		for _, f := range optionalFormats {
+			if f.Name == name {
+				found = f
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("invalid format %q", name)
+		}
+		if !slices.Contains(extraFormats, found) {
+			extraFormats = append(extraFormats, found)
+		}
+	}
+	formats = append([]*outputFormat{textFormat}, extraFormats...)
+	return nil
+}
+
+// formatNames returns the names of all rendered formats, which are
+// part of the build state.
+func formatNames() []string {
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// formatPath returns the path of the version in format f of the
+// rendered page dest.
+func formatPath(dest string, f *outputFormat) string {
+	return strings.TrimSuffix(dest, ".html.gz") + f.Suffix + ".gz"
+}
+
+// convertFormat renders the manpage src in format f.
+func convertFormat(src string, f *outputFormat) (string, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if st, err := file.Stat(); err == nil && st.Size() == 0 {
+		// like the HTML page
+		return "This space intentionally left blank.\n", nil
+	}
+
+	r, _, err := decompress.Detect(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	out, err := f.convert(r)
+	if err != nil {
+		return "", fmt.Errorf("convert(%q): %v", src, err)
+	}
+	return out, nil
+}
+
+// renderFormat writes the version in format f of the manpage of job
+// and returns true if it was written. If mandoc fails, no file is
+// written (a file of the last run is removed) and the error counts
+// against the error budget. The page is rendered again in the next
+// run, as the file is missing.
+func renderFormat(gzipw *gzip.Writer, job renderJob, f *outputFormat, gv *globalView) (uint64, bool, error) {
+	dest := formatPath(job.dest, f)
+	out, err := convertFormat(job.src, f)
+	if err != nil {
+		gv.errors.add(job.meta.Package.Product, errorRender, job.meta.Package.Filename,
+			fmt.Errorf("rendering %s: %v", relServingPath(dest), err))
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return 0, false, err
+		}
+		return 0, false, nil
+	}
+
+	var written countingWriter
+	if err := write.AtomicallyWithGz(dest, gzipw, func(w io.Writer) error {
+		_, err := io.WriteString(io.MultiWriter(w, &written), out)
+		return err
+	}); err != nil {
+		return 0, false, err
+	}
+	return uint64(written), true, nil
+}
@@ -1,27 +1,42 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"io/fs"
+	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
 	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+	"github.com/thkukuk/rpm2docserv/pkg/pkgsource"
 	"github.com/thkukuk/rpm2docserv/pkg/rpm"
+	"github.com/thkukuk/rpm2docserv/pkg/rpmmd"
 
 	"github.com/knqyf263/go-rpm-version"
+	"golang.org/x/sync/errgroup"
 )
 
 type stats struct {
 	TotalNumberPkgs   uint64
 	PackagesExtracted uint64
 	ManpagesRendered  uint64
+	ManpagesUnchanged uint64
 	ManpageBytes      uint64
 	HTMLBytes         uint64
+	// FormatBytes are the bytes of the other formats by name
+	FormatBytes map[string]*uint64
 	IndexBytes        uint64
+
+	SignaturesValid    uint64
+	SignaturesUnsigned uint64
+	SignaturesBad      uint64
 }
 
 type globalView struct {
@@ -37,6 +52,20 @@ type globalView struct {
 	// should the product be rendered?
 	renderProduct map[string]bool
 
+	// roots of the manual pages per product
+	manPaths map[string][]string
+
+	// preferred architectures per product, best first
+	archs map[string][]string
+
+	// keyring and policy to verify the packages per product, nil
+	// if the signatures are not checked
+	signatures map[string]*signatureCheck
+
+	// errors of this run and the error budget per product
+	errors       *runErrors
+	errorBudgets map[string]errorBudget
+
         // productMapping maps codename and products
 	// e.g. map[MicroOS:Tumbleweed Tumbleweed:Tumbleweed]
         productMapping map[string]string
@@ -45,10 +74,35 @@ type globalView struct {
 	// the corresponding manpage.Meta.
 	xref map[string][]*manpage.Meta
 
+	// number of older versions of a package to keep per product
+	keepVersions map[string]int
+
+	// older is like xref, but contains the manpages of the older
+	// versions kept because of keep_versions. Neither cross
+	// references nor the auxserver index point to them.
+	older map[string][]*manpage.Meta
+
+	// files is the file index of each product: it maps the path of
+	// every manpage (without compression suffix) to the latest
+	// version of the package containing it. Links pointing into
+	// other packages are resolved with it.
+	files map[string]map[string]packageFile
+
+	// lastState is the build state of the last run, state
+	// the one of this run.
+	lastState *buildState
+	state     *buildState
+
 	stats *stats
 	start time.Time
 }
 
+// packageFile is a manpage in the file list of a package
+type packageFile struct {
+	pkg  *manpage.PkgMeta
+	path string
+}
+
 type byProductPkgVer []*manpage.PkgMeta
 func (p byProductPkgVer) Len() int      { return len(p) }
 func (p byProductPkgVer) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
@@ -95,14 +149,56 @@ func (p byProductStr) Less(i, j int) bool {
 	return orderi < orderj
 }
 
-var manPrefix = "/usr/share/man/"
-var gzSuffix = ".gz"
+var scanConcurrency = flag.Int("concurrency_scan",
+	runtime.NumCPU(),
+	"Concurrency level for reading the headers of all RPMs")
+
+// defaultManPaths are the roots of the manual pages, if a product
+// does not configure its own.
+var defaultManPaths = []string{"/usr/share/man"}
+
+// productManPaths returns the roots of the manual pages of product.
+func productManPaths(product Product) []string {
+	if len(product.ManPaths) > 0 {
+		return product.ManPaths
+	}
+	return defaultManPaths
+}
+
+// manRoot returns the root directory of the file name and the path
+// relative to it, if name is below one of the manpaths. The manpaths
+// can contain shell patterns, e.g. "/opt/*/share/man".
+func manRoot(manpaths []string, name string) (root string, rel string, ok bool) {
+	for _, mp := range manpaths {
+		mp = path.Clean(mp)
+		n := strings.Count(mp, "/")
+		parts := strings.SplitN(name, "/", n+2)
+		if len(parts) < n+2 {
+			continue
+		}
+		root := strings.Join(parts[:n+1], "/")
+		if matched, _ := path.Match(mp, root); matched {
+			return root, parts[n+1], true
+		}
+	}
+	return "", "", false
+}
+
+// manpageFromPath constructs a manpage from the full path of the
+// file in the package.
+func manpageFromPath(manpaths []string, name string, p *manpage.PkgMeta) (*manpage.Meta, error) {
+	_, rel, ok := manRoot(manpaths, name)
+	if !ok {
+		return nil, fmt.Errorf("%q is not below any manpath", name)
+	}
+	return manpage.FromManPath(rel, p)
+}
 
-func markPresent(latestVersion map[string]*manpage.PkgMeta, xref map[string][]*manpage.Meta, filename string, key string) error {
+func markPresent(latestVersion map[string]*manpage.PkgMeta, xref map[string][]*manpage.Meta, manpaths []string, filename string, key string) error {
         if _, ok := latestVersion[key]; !ok {
                 return fmt.Errorf("Could not determine latest version")
         }
-        m, err := manpage.FromManPath(strings.TrimPrefix(filename, manPrefix), latestVersion[key])
+        m, err := manpageFromPath(manpaths, filename, latestVersion[key])
         if err != nil {
                 return fmt.Errorf("Trying to interpret path %q: %v", filename, err)
         }
@@ -125,19 +221,194 @@ func markPresent(latestVersion map[string]*manpage.PkgMeta, xref map[string][]*m
         return nil
 }
 
-// Get the filelist of an RPM and store the filename of all manual pages
-// found in that RPM
-func getManpageList(fn string) ([]string, error) {
-	var manpageList []string
+// markFiles adds the manpages of pkg to the file index. If several
+// packages contain the same file, the first one wins.
+func markFiles(files map[string]map[string]packageFile, pkg *manpage.PkgMeta) {
+	index, ok := files[pkg.Product]
+	if !ok {
+		index = make(map[string]packageFile)
+		files[pkg.Product] = index
+	}
+	for _, f := range pkg.ManpageList {
+		key := decompress.TrimSuffix(f)
+		if _, ok := index[key]; !ok {
+			index[key] = packageFile{pkg: pkg, path: f}
+		}
+	}
+}
 
-	filelist, err := rpm.GetRPMFilelist (fn)
+// markOlder adds the manpage filename of pkg, an older version of a
+// package, to older.
+func markOlder(older map[string][]*manpage.Meta, manpaths []string, filename string, pkg *manpage.PkgMeta) error {
+	m, err := manpageFromPath(manpaths, filename, pkg)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("Trying to interpret path %q: %v", filename, err)
+	}
+	for _, x := range older[m.Name] {
+		if x.ServingPath() == m.ServingPath() {
+			return nil
+		}
+	}
+	older[m.Name] = append(older[m.Name], m)
+	return nil
+}
+
+// Check if name looks like a manual page: it must be below one of
+// the manpaths and compressed or inside a man<section> directory.
+func isManpageName(name string, manpaths []string) bool {
+	if _, _, ok := manRoot(manpaths, name); !ok {
+		return false
+	}
+	if decompress.FromFilename(name) != decompress.None {
+		return true
+	}
+	return strings.HasPrefix(path.Base(path.Dir(name)), "man") && strings.Contains(path.Base(name), ".")
+}
+
+// archRank returns the position of arch in the preferred architectures
+// archs of a product, lower is better. Architecture independent
+// packages match every preference. If arch is not wanted at all,
+// false is returned. Without preferences, every arch is equally good.
+func archRank(archs []string, arch string) (int, bool) {
+	if len(archs) == 0 {
+		return 0, true
+	}
+	for i, a := range archs {
+		if a == arch {
+			return i, true
+		}
+	}
+	if arch == "noarch" || arch == "all" {
+		return 0, true
+	}
+	return 0, false
+}
+
+// markArchSpecific compares the manpages of the latest builds of each
+// package for all wanted architectures. Manpages of the selected build
+// missing in other builds are recorded in PkgMeta.ArchSpecific,
+// manpages missing in the selected build are reported.
+func markArchSpecific(latestVersion map[string]*manpage.PkgMeta, gv *globalView) {
+	// manpages per package and architecture
+	byArch := make(map[string]map[string]map[string]bool)
+	for _, pkg := range gv.pkgs {
+		if _, ok := archRank(gv.archs[pkg.Product], pkg.Arch); !ok {
+			continue
+		}
+		key := pkg.Product + "/" + pkg.Binarypkg
+		if byArch[key] == nil {
+			byArch[key] = make(map[string]map[string]bool)
+		}
+		if _, exists := byArch[key][pkg.Arch]; exists {
+			// lower version
+			continue
+		}
+		manpages := make(map[string]bool, len(pkg.ManpageList))
+		for _, f := range pkg.ManpageList {
+			manpages[decompress.TrimSuffix(f)] = true
+		}
+		byArch[key][pkg.Arch] = manpages
+	}
+
+	for key, archs := range byArch {
+		if len(archs) < 2 {
+			continue
+		}
+		latest := latestVersion[key]
+		names := make([]string, 0, len(archs))
+		for arch := range archs {
+			names = append(names, arch)
+		}
+		sort.Strings(names)
+
+		for _, f := range latest.ManpageList {
+			var found []string
+			for _, arch := range names {
+				if archs[arch][decompress.TrimSuffix(f)] {
+					found = append(found, arch)
+				}
+			}
+			if len(found) == len(names) {
+				continue
+			}
+			m, err := manpageFromPath(gv.manPaths[latest.Product], f, latest)
+			if err != nil {
+				continue
+			}
+			if latest.ArchSpecific == nil {
+				latest.ArchSpecific = make(map[string][]string)
+			}
+			latest.ArchSpecific[m.ServingPath()] = found
+		}
+
+		for _, arch := range names {
+			if arch == latest.Arch {
+				continue
+			}
+			for f := range archs[arch] {
+				if !archs[latest.Arch][f] {
+					log.Printf("%s: %s only exists for %s, not for the selected %s", key, f, arch, latest.Arch)
+				}
+			}
+		}
+	}
+}
+
+// repoAlternatives returns the manpages of the RPM fn from a rpm-md
+// repository, which are installed as alternative.
+func repoAlternatives(fn string, rs *rpmState) []string {
+	hdr, err := pkgsource.ReadPackage(fn)
+	if err != nil {
+		log.Printf("Cannot read scripts of %q: %v", filepath.Base(fn), err)
+		return nil
 	}
+	return manpageAlternatives(packageAlternatives(hdr), rs.ManpageList)
+}
+
+// markAlternatives records for all manpages installed as alternative
+// the binary packages of the product providing them.
+func markAlternatives(latestVersion map[string]*manpage.PkgMeta, gv *globalView) {
+	providers := make(map[string]map[string]bool)
+	for _, pkg := range gv.pkgs {
+		for _, f := range gv.state.product(pkg.Product).RPMs[pkg.Filename].Alternatives {
+			key := pkg.Product + "/" + decompress.TrimSuffix(f)
+			if providers[key] == nil {
+				providers[key] = make(map[string]bool)
+			}
+			providers[key][pkg.Binarypkg] = true
+		}
+	}
+
+	for _, pkg := range gv.pkgs {
+		if latestVersion[pkg.Product+"/"+pkg.Binarypkg] != pkg {
+			continue
+		}
+		for _, f := range gv.state.product(pkg.Product).RPMs[pkg.Filename].Alternatives {
+			m, err := manpageFromPath(gv.manPaths[pkg.Product], f, pkg)
+			if err != nil {
+				continue
+			}
+			var names []string
+			for name := range providers[pkg.Product+"/"+decompress.TrimSuffix(f)] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if pkg.Alternatives == nil {
+				pkg.Alternatives = make(map[string][]string)
+			}
+			pkg.Alternatives[m.ServingPath()] = names
+		}
+	}
+}
 
-	for _, filename := range filelist {
-		if strings.HasPrefix(filename, manPrefix) && strings.HasSuffix(filename, gzSuffix){
-			manpageList = append(manpageList, filename)
+// Go through the filelist of a package and store the filename of all
+// manual pages found in that package
+func getManpageList(filelist []pkgsource.File, manpaths []string) []string {
+	var manpageList []string
+
+	for _, f := range filelist {
+		if !f.Mode.IsDir() && isManpageName(f.Name, manpaths) {
+			manpageList = append(manpageList, f.Name)
 		}
 	}
 	if  len(manpageList) > 0 {
@@ -150,95 +421,419 @@ func getManpageList(fn string) ([]string, error) {
 			return len(manpageList[j]) < len(manpageList[k])
 		})
 	}
-	return manpageList, nil
+	return manpageList
+}
+
+// Check if a package of a rpm-md repository contains manual pages
+func repoHasManpages(p *rpmmd.Package, manpaths []string) bool {
+	for _, f := range p.Files {
+		if f.Type != "dir" && isManpageName(f.Name, manpaths) {
+			return true
+		}
+	}
+	return false
+}
+
+type scanJob struct {
+	product string
+	path    string
+}
+
+// Read the header of a RPM and create a package entry for it.
+// If the RPM did not change since the last run, the data is taken
+// from the build state instead of reading the RPM again.
+// Returns nil if the RPM contains no manual pages or cannot be read
+// or verified.
+func scanPackage(job scanJob, gv *globalView) (*manpage.PkgMeta, error) {
+	fi, err := pkgsource.Stat(job.path)
+	if err != nil {
+		gv.errors.add(job.product, errorScan, job.path, fmt.Errorf("ignoring %q: %v", filepath.Base(job.path), err))
+		return nil, nil
+	}
+
+	rs := gv.lastState.lookup(job.product, job.path, fi)
+	if rs != nil {
+		cached := *rs
+		rs = &cached
+	} else {
+		hdr, err := pkgsource.ReadPackage(job.path)
+		if err != nil {
+			gv.errors.add(job.product, errorScan, job.path, fmt.Errorf("ignoring %q: %v", filepath.Base(job.path), err))
+			return nil, nil
+		}
+
+		rs = &rpmState{
+			Size:        fi.Size(),
+			ModTime:     fi.ModTime(),
+			Name:        hdr.Name,
+			Sourcepkg:   hdr.Source,
+			Version:     hdr.Version,
+			Arch:        hdr.Arch,
+			ManpageList: getManpageList(hdr.Files, gv.manPaths[job.product]),
+		}
+		rs.Alternatives = manpageAlternatives(packageAlternatives(hdr), rs.ManpageList)
+	}
+
+	// Only packages with manpages are used and need to be verified
+	if len(rs.ManpageList) > 0 {
+		if ok, err := checkSignature(job.product, job.path, rs, gv); !ok {
+			return nil, err
+		}
+	}
+	gv.state.setRPM(job.product, job.path, rs)
+
+	if len(rs.ManpageList) == 0 {
+		return nil, nil
+	}
+
+	pkg := new(manpage.PkgMeta)
+	pkg.Sourcepkg = rs.Sourcepkg
+	pkg.Product = job.product
+	pkg.Filename = job.path
+	pkg.ManpageList = rs.ManpageList
+	pkg.Binarypkg = rs.Name
+	pkg.Version = version.NewVersion(rs.Version)
+	pkg.Arch = rs.Arch
+
+	return pkg, nil
+}
+
+// Read the metadata of a rpm-md repository and create a package
+// entry for all packages containing manual pages. The RPMs are not
+// opened, the file list is taken from filelists.xml.
+// For remote repositories the local mirror is used.
+func scanRepo(product Product, baseurl string, gv *globalView) ([]*manpage.PkgMeta, error) {
+	if rpmmd.IsRemote(baseurl) {
+		baseurl = repoMirror(product, baseurl)
+	}
+	repo, err := rpmmd.Open(baseurl)
+	if err != nil {
+		return nil, err
+	}
+
+	var total uint64
+	pkgs, err := repo.Packages(func(p *rpmmd.Package) bool {
+		total++
+		return repoHasManpages(p, productManPaths(product))
+	})
+	if err != nil {
+		return nil, err
+	}
+	gv.stats.TotalNumberPkgs += total
+
+	var result []*manpage.PkgMeta
+	for _, p := range pkgs {
+		if gv.errors.failedUnit(product.Name, p.Location) {
+			// failed to download, already counted
+			continue
+		}
+		fn, err := repo.PackagePath(p)
+		if err == nil {
+			_, err = os.Stat(fn)
+		}
+		if err != nil {
+			gv.errors.add(product.Name, errorScan, p.Location, fmt.Errorf("ignoring %q: %v", p.Location, err))
+			continue
+		}
+
+		ghosts := false
+		files := make([]pkgsource.File, 0, len(p.Files))
+		for _, f := range p.Files {
+			file := pkgsource.File{Name: f.Name}
+			switch f.Type {
+			case "dir":
+				file.Mode = fs.ModeDir
+			case "ghost":
+				file.Ghost = true
+				ghosts = true
+			}
+			files = append(files, file)
+		}
+
+		rs := &rpmState{
+			Size:        p.Size,
+			ModTime:     time.Unix(p.Time, 0),
+			Checksum:    p.Checksum,
+			Name:        p.Name,
+			Version:     rpm.EVR(p.Epoch, p.Version, p.Release),
+			Arch:        p.Arch,
+			ManpageList: getManpageList(files, productManPaths(product)),
+		}
+		rs.Sourcepkg, _, _, _, _ = rpm.SplitRPMname(p.SourceRPM)
+		old := gv.lastState.lookupChecksum(product.Name, fn, rs.Checksum)
+		if ok, err := checkSignature(product.Name, fn, rs, gv); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if old != nil {
+			rs.Alternatives = old.Alternatives
+			rs.Manpages = old.Manpages
+			rs.Aliases = old.Aliases
+			rs.Problems = old.Problems
+		} else if ghosts {
+			// The scripts are not part of the metadata, only
+			// read them if a manpage could be an alternative.
+			rs.Alternatives = repoAlternatives(fn, rs)
+		}
+		gv.state.setRPM(product.Name, fn, rs)
+
+		pkg := new(manpage.PkgMeta)
+		pkg.Sourcepkg = rs.Sourcepkg
+		pkg.Product = product.Name
+		pkg.Filename = fn
+		pkg.ManpageList = rs.ManpageList
+		pkg.Binarypkg = rs.Name
+		pkg.Version = version.NewVersion(rs.Version)
+		pkg.Arch = rs.Arch
+		result = append(result, pkg)
+	}
+	return result, nil
+}
+
+// Read the rpm database below the root directory of product and
+// create a package entry for all installed packages containing manual
+// pages. The manual pages are read from the root directory later.
+// The signatures are not verified, rpm did this on installation.
+func scanInstalled(product Product, gv *globalView) ([]*manpage.PkgMeta, error) {
+	root := product.Root
+	if root == "" {
+		root = "/"
+	}
+	pkgs, err := pkgsource.ReadInstalled(root)
+	if err != nil {
+		return nil, err
+	}
+	gv.stats.TotalNumberPkgs += uint64(len(pkgs))
+
+	var result []*manpage.PkgMeta
+	for _, p := range pkgs {
+		rs := &rpmState{
+			Checksum:    p.Digest,
+			Name:        p.Name,
+			Sourcepkg:   p.Source,
+			Version:     p.Version,
+			Arch:        p.Arch,
+			ManpageList: getManpageList(p.Files, gv.manPaths[product.Name]),
+		}
+		if len(rs.ManpageList) == 0 {
+			continue
+		}
+		rs.Alternatives = manpageAlternatives(packageAlternatives(p.Package), rs.ManpageList)
+		if old := gv.lastState.lookupChecksum(product.Name, p.Path, rs.Checksum); old != nil {
+			rs.Manpages = old.Manpages
+			rs.Aliases = old.Aliases
+			rs.Problems = old.Problems
+		}
+		gv.state.setRPM(product.Name, p.Path, rs)
+
+		pkg := new(manpage.PkgMeta)
+		pkg.Sourcepkg = rs.Sourcepkg
+		pkg.Product = product.Name
+		pkg.Filename = p.Path
+		pkg.ManpageList = rs.ManpageList
+		pkg.Binarypkg = rs.Name
+		pkg.Version = version.NewVersion(rs.Version)
+		pkg.Arch = rs.Arch
+		result = append(result, pkg)
+	}
+	return result, nil
 }
 
 // go through the cache directory, find all RPMs and build a pkg entry for it
-func buildGlobalView(products []Product, start time.Time) (globalView, error) {
-	var stats stats
+func buildGlobalView(products []Product, lastState *buildState, errs *runErrors, start time.Time) (globalView, error) {
+	stats := stats{
+		FormatBytes: make(map[string]*uint64, len(formats)),
+	}
+	for _, f := range formats {
+		stats.FormatBytes[f.Name] = new(uint64)
+	}
 	res := globalView{
 		products:       make(map[string]bool, len(products)),
 		productList:    make([]string, 0, len(products)),
 		productMapping: make(map[string]string, len(products)),
 		renderProduct:  make(map[string]bool, len(products)),
+		manPaths:       make(map[string][]string, len(products)),
+		archs:          make(map[string][]string, len(products)),
+		signatures:     make(map[string]*signatureCheck, len(products)),
+		errors:         errs,
+		errorBudgets:   make(map[string]errorBudget, len(products)),
 		xref:           make(map[string][]*manpage.Meta),
+		keepVersions:   make(map[string]int, len(products)),
+		older:          make(map[string][]*manpage.Meta),
+		files:          make(map[string]map[string]packageFile, len(products)),
+		lastState:      lastState,
+		state:          newBuildState(),
 		stats:          &stats,
 		start:          start,
 	}
 
+	var jobs []scanJob
 	for _, product := range products {
 
 		res.productList = append(res.productList, product.Name)
 		res.products[product.Name] = true
 		res.productMapping[product.Name] = product.Name
 		res.renderProduct[product.Name] = ! product.NoRender
+		res.manPaths[product.Name] = productManPaths(product)
+		res.archs[product.Name] = product.Arch
+		res.keepVersions[product.Name] = product.KeepVersions
+		sc, err := readSignatureCheck(product)
+		if err != nil {
+			return res, fmt.Errorf("reading gpgkeys of %q: %v", product.Name, err)
+		}
+		res.signatures[product.Name] = sc
+		// validated by setupErrorBudgets
+		res.errorBudgets[product.Name], _ = parseErrorBudget(product.ErrorBudget)
 		for _, alias := range product.Alias {
 			res.productMapping[alias] = product.Name
 		}
 
-		// Walk recursivly through the full cache directory, search all
-		// RPMs and store the meta data for them.
+		// If the configuration of the product changed, the
+		// result of the last run cannot be used.
+		settings := productSettings(product)
+		if ps, ok := lastState.Products[product.Name]; ok && ps.Settings != settings {
+			log.Printf("Configuration of %q changed, doing a full rebuild", product.Name)
+			delete(lastState.Products, product.Name)
+		}
+		res.state.product(product.Name).Settings = settings
+
+		for _, baseurl := range product.Repos {
+			if *verbose {
+				log.Printf("Read repository %q for %q...", baseurl, product.Name)
+			}
+			pkgs, err := scanRepo(product, baseurl, &res)
+			if err != nil {
+				return res, fmt.Errorf("reading repository %q: %v", baseurl, err)
+			}
+			res.pkgs = append(res.pkgs, pkgs...)
+		}
+
+		if product.Installed {
+			pkgs, err := scanInstalled(product, &res)
+			if err != nil {
+				return res, fmt.Errorf("reading installed packages of %q: %v", product.Name, err)
+			}
+			res.pkgs = append(res.pkgs, pkgs...)
+		}
+
+		if product.Directory != nil {
+			pkgs, err := scanDirectory(product, &res)
+			if err != nil {
+				return res, fmt.Errorf("reading directory of %q: %v", product.Name, err)
+			}
+			res.pkgs = append(res.pkgs, pkgs...)
+		}
+
+		// The mirrors of remote repositories are inside the cache
+		// directory, but they were already read above.
+		mirrors := make(map[string]bool)
+		for _, baseurl := range product.Repos {
+			if rpmmd.IsRemote(baseurl) {
+				mirrors[repoMirror(product, baseurl)] = true
+			}
+		}
+
+		// Walk recursivly through the full cache directory and
+		// search all packages (RPMs and .debs). The meta data is
+		// read later in parallel.
 		for i := range product.Cache {
 			if *verbose {
 				log.Printf("Read %q from %q...", product.Cache[i], product.Name)
 			}
-			err := filepath.WalkDir(product.Cache[i],
-				func(path string, di fs.DirEntry, err error) error {
-					if err != nil {
-						return err
-					}
-					if strings.HasSuffix(path, ".rpm") {
-						res.stats.TotalNumberPkgs++
-						rpmname := filepath.Base(path)
-						binarypkg, rpmversion, rpmrelease, _, sourcepkg, err := rpm.GetRPMHeader(path)
-						if err != nil {
-							log.Printf("Ignoring %q: %v\n", rpmname, err)
-							return nil
-						}
-
-						manpageList, err := getManpageList(path)
-						if err != nil {
-							log.Printf("Ignoring %q: %v\n", path, err)
-							return nil
-						}
-						if len(manpageList) == 0 {
-							return nil
-						}
-
-						// Add RPM to package list
-						pkg := new(manpage.PkgMeta)
-						pkg.Sourcepkg, _, _, _, err = rpm.SplitRPMname(sourcepkg) // sourcepkg
-						pkg.Product = product.Name
-						pkg.Filename = path
-						pkg.ManpageList = manpageList
-						pkg.Binarypkg = binarypkg
-						pkg.Version = version.NewVersion(rpmversion + "-" + rpmrelease)
-
-						res.pkgs = append (res.pkgs, pkg)
-					}
-					return nil
+			files, err := pkgsource.List(product.Cache[i], func(path string) bool {
+				return mirrors[path]
+			})
+			for _, fn := range files {
+				res.stats.TotalNumberPkgs++
+				jobs = append(jobs, scanJob{
+					product: product.Name,
+					path:    fn,
 				})
+			}
 			if err != nil {
 				return res, fmt.Errorf("WalkDir(%q): %v", product.Cache[i], err)
 			}
 		}
 	}
 
+	// Read the RPM headers in parallel. Every worker writes only
+	// its own slot of results, so that the order of res.pkgs does
+	// not depend on the scheduling.
+	results := make([]*manpage.PkgMeta, len(jobs))
+	var eg errgroup.Group
+	eg.SetLimit(*scanConcurrency)
+	for i := range jobs {
+		eg.Go(func() error {
+			var err error
+			results[i], err = scanPackage(jobs[i], &res)
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return res, err
+	}
+	for _, pkg := range results {
+		if pkg != nil {
+			res.pkgs = append(res.pkgs, pkg)
+		}
+	}
+
 	// sort product list according to product sort order.
 	sort.Stable(byProductStr(res.productList))
 
 	// sort the package list, so that packages with a higher version comes first
 	sort.Stable(byProductPkgVer(res.pkgs))
 
-	// build an index with the latest version of a package,
-	// ignoring all lower versions of the same package
+	// build an index with the latest version of a package in the
+	// best matching architecture, ignoring all lower versions of
+	// the same package
 	latestVersion := make(map[string]*manpage.PkgMeta)
+	latestRank := make(map[string]int)
 	for _, pkg := range res.pkgs {
+		rank, ok := archRank(res.archs[pkg.Product], pkg.Arch)
+		if !ok {
+			continue
+		}
 		key := pkg.Product + "/" + pkg.Binarypkg
-		if _, exists := latestVersion[key]; !exists {
+		if best, exists := latestRank[key]; !exists || rank < best {
 			latestVersion[key] = pkg
+			latestRank[key] = rank
 		}
 	}
+	markArchSpecific(latestVersion, &res)
+
+	// Only the builds for the selected architecture are extracted,
+	// all of them would write the same files. Of the lower versions
+	// only as many as configured with keep_versions are kept, they
+	// come after the latest one in res.pkgs.
+	selected := res.pkgs[:0]
+	kept := make(map[string]*manpage.PkgMeta)
+	numOlder := make(map[string]int)
+	for _, pkg := range res.pkgs {
+		key := pkg.Product + "/" + pkg.Binarypkg
+		latest, ok := latestVersion[key]
+		if !ok || latest.Arch != pkg.Arch {
+			if *verbose {
+				log.Printf("Ignoring %q: architecture %q not selected", filepath.Base(pkg.Filename), pkg.Arch)
+			}
+			continue
+		}
+		if pkg != latest {
+			if pkg.Version.Equal(kept[key].Version) || numOlder[key] >= res.keepVersions[pkg.Product] {
+				if *verbose {
+					log.Printf("Ignoring %q: older version %s", filepath.Base(pkg.Filename), pkg.Version.String())
+				}
+				continue
+			}
+			pkg.Older = true
+			numOlder[key]++
+		}
+		kept[key] = pkg
+		selected = append(selected, pkg)
+	}
+	res.pkgs = selected
+	markAlternatives(latestVersion, &res)
 
 	knownIssues := make(map[string][]error)
 
@@ -249,8 +844,17 @@ func buildGlobalView(products []Product, start time.Time) (globalView, error) {
 		}
 
 		key := pkg.Product + "/" + pkg.Binarypkg
+		if pkg.Older {
+			for _, f := range pkg.ManpageList {
+				if err := markOlder(res.older, res.manPaths[pkg.Product], f, pkg); err != nil {
+					knownIssues[key] = append(knownIssues[key], err)
+				}
+			}
+			continue
+		}
+		markFiles(res.files, pkg)
 		for _, f := range pkg.ManpageList {
-			if err := markPresent(latestVersion, res.xref, strings.TrimPrefix(f, manPrefix), key); err != nil {
+			if err := markPresent(latestVersion, res.xref, res.manPaths[pkg.Product], f, key); err != nil {
 				knownIssues[key] = append(knownIssues[key], err)
 			}
 		}
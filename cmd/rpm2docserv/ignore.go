@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IgnoreRule matches binary packages for which known errors during
+// the extraction of the manual pages should not be reported.
+// Exactly one of Package, Prefix and Glob must be set.
+type IgnoreRule struct {
+	// Package is the exact name of the binary package
+	Package string `yaml:"package,omitempty"`
+	// Prefix matches all packages starting with it
+	Prefix string `yaml:"prefix,omitempty"`
+	// Glob is a shell pattern as understood by path.Match
+	Glob string `yaml:"glob,omitempty"`
+	// Reason documents why the errors can be ignored
+	Reason string `yaml:"reason,omitempty"`
+}
+
+const (
+	extractErrors = "ignore_extract_errors"
+	linkErrors    = "ignore_link_errors"
+)
+
+var (
+	// Used if no rule is configured at all, to keep the behaviour of
+	// older versions without ignore rules in the configuration.
+	defaultIgnoreExtractErrors = []IgnoreRule{
+		{Prefix: "inn", Reason: "conflicts with mininews, both are built from the same source with identical manpages"},
+		{Prefix: "mininews", Reason: "conflicts with inn, both are built from the same source with identical manpages"},
+		{Prefix: "python3", Reason: "identical manpages for different python versions via update-alternatives"},
+	}
+	defaultIgnoreLinkErrors = []IgnoreRule{
+		{Prefix: "qelectrotech", Reason: "ships french manpages for different locales, only one is needed"},
+		{Prefix: "wireless-tools"},
+	}
+)
+
+func (r IgnoreRule) String() string {
+	switch {
+	case r.Package != "":
+		return fmt.Sprintf("package %q", r.Package)
+	case r.Prefix != "":
+		return fmt.Sprintf("prefix %q", r.Prefix)
+	}
+	return fmt.Sprintf("glob %q", r.Glob)
+}
+
+func (r IgnoreRule) validate() error {
+	set := 0
+	for _, s := range []string{r.Package, r.Prefix, r.Glob} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of package, prefix and glob must be set")
+	}
+	if r.Glob != "" {
+		if _, err := path.Match(r.Glob, ""); err != nil {
+			return fmt.Errorf("glob %q: %v", r.Glob, err)
+		}
+	}
+	return nil
+}
+
+func (r IgnoreRule) matches(pkg string) bool {
+	switch {
+	case r.Package != "":
+		return pkg == r.Package
+	case r.Prefix != "":
+		return strings.HasPrefix(pkg, r.Prefix)
+	}
+	ok, _ := path.Match(r.Glob, pkg)
+	return ok
+}
+
+type ignoreRule struct {
+	IgnoreRule
+	// kind is extractErrors or linkErrors
+	kind string
+	// product is empty for global rules
+	product string
+	// matched are the packages for which errors were ignored
+	matched map[string]bool
+}
+
+type ignoreRules struct {
+	mu    sync.Mutex
+	rules []*ignoreRule
+}
+
+var ignores ignoreRules
+
+// add validates and adds the rules of kind for product (empty for
+// global rules).
+func (ir *ignoreRules) add(kind string, product string, rules []IgnoreRule) error {
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			if product != "" {
+				return fmt.Errorf("product %q: %s[%d]: %v", product, kind, i, err)
+			}
+			return fmt.Errorf("%s[%d]: %v", kind, i, err)
+		}
+		ir.rules = append(ir.rules, &ignoreRule{
+			IgnoreRule: r,
+			kind:       kind,
+			product:    product,
+			matched:    make(map[string]bool),
+		})
+	}
+	return nil
+}
+
+// ignored returns true if errors of kind for the binary package pkg
+// of product should not be reported.
+func (ir *ignoreRules) ignored(kind string, product string, pkg string) bool {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	ignored := false
+	for _, r := range ir.rules {
+		if r.kind != kind || (r.product != "" && r.product != product) {
+			continue
+		}
+		if r.matches(pkg) {
+			r.matched[product+"/"+pkg] = true
+			ignored = true
+		}
+	}
+	return ignored
+}
+
+// report logs for every rule, for which packages errors were ignored,
+// so that rules no longer needed can be found.
+func (ir *ignoreRules) report() {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	if len(ir.rules) == 0 {
+		return
+	}
+	log.Printf("Ignore rules:")
+	for _, r := range ir.rules {
+		name := r.kind + " " + r.String()
+		if r.product != "" {
+			name += fmt.Sprintf(" (product %q)", r.product)
+		}
+		if len(r.matched) == 0 {
+			log.Printf("  %s: never matched", name)
+			continue
+		}
+		pkgs := make([]string, 0, len(r.matched))
+		for pkg := range r.matched {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		log.Printf("  %s: matched %s", name, strings.Join(pkgs, ", "))
+	}
+}
+
+// setupIgnoreRules reads the global and per product rules. If none
+// are configured at all, the built-in defaults are used.
+func setupIgnoreRules(config Config, products []Product) error {
+	configured := len(config.IgnoreExtractErrors) > 0 || len(config.IgnoreLinkErrors) > 0
+	for _, product := range products {
+		if len(product.IgnoreExtractErrors) > 0 || len(product.IgnoreLinkErrors) > 0 {
+			configured = true
+		}
+	}
+	if !configured {
+		config.IgnoreExtractErrors = defaultIgnoreExtractErrors
+		config.IgnoreLinkErrors = defaultIgnoreLinkErrors
+	}
+
+	if err := ignores.add(extractErrors, "", config.IgnoreExtractErrors); err != nil {
+		return err
+	}
+	if err := ignores.add(linkErrors, "", config.IgnoreLinkErrors); err != nil {
+		return err
+	}
+	for _, product := range products {
+		if err := ignores.add(extractErrors, product.Name, product.IgnoreExtractErrors); err != nil {
+			return err
+		}
+		if err := ignores.add(linkErrors, product.Name, product.IgnoreLinkErrors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
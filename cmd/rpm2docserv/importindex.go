@@ -7,6 +7,8 @@ import (
 	"github.com/thkukuk/rpm2docserv/pkg/redirect"
 	"github.com/thkukuk/rpm2docserv/pkg/tag"
 	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+
+	"github.com/knqyf263/go-rpm-version"
 )
 
 func importIndex(index string, gv *globalView) error {
@@ -24,6 +26,7 @@ func importIndex(index string, gv *globalView) error {
 			pkg := &manpage.PkgMeta{
 				Product: entry.Product,
 				Binarypkg: entry.Binarypkg,
+				Version: version.NewVersion(entry.Version),
 			}
 			m := &manpage.Meta{
 				Name: entry.Name,
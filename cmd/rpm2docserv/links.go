@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+)
+
+// States of a manLinks entry while it is resolved
+const (
+	linkPending = iota
+	linkResolving
+	linkResolved
+	linkFailed
+)
+
+// maxLinkDepth is the maximal length of a chain of links between
+// packages, which is followed.
+const maxLinkDepth = 8
+
+// linkResolver links the manpages of a product, which point to files
+// not part of their source package, to their targets. The target is
+// looked up in the file index of the product. If it is a link into
+// another package itself, that one is resolved first.
+type linkResolver struct {
+	servingDir string
+	product    string
+	gv         *globalView
+
+	// pending maps the package and path of the links to resolve
+	pending map[packageFile]*manLinks
+}
+
+func newLinkResolver(servingDir string, product string, missing []*manLinks, gv *globalView) *linkResolver {
+	r := &linkResolver{
+		servingDir: servingDir,
+		product:    product,
+		gv:         gv,
+		pending:    make(map[packageFile]*manLinks, len(missing)),
+	}
+	for _, l := range missing {
+		r.pending[packageFile{pkg: l.pkg, path: l.path}] = l
+	}
+	return r
+}
+
+// resolve links l to its target and records the problems, if this
+// fails. It returns nil if l could be linked.
+func (r *linkResolver) resolve(l *manLinks, depth int) error {
+	switch l.state {
+	case linkResolving:
+		return &refError{problemLinkCycle,
+			fmt.Errorf("%q of %s is part of a link cycle", l.path, l.binarypkg)}
+	case linkResolved:
+		return nil
+	case linkFailed:
+		return l.resolveErr
+	}
+	if depth > maxLinkDepth {
+		return &refError{problemLinkDepth,
+			fmt.Errorf("more than %d links to follow for %q of %s", maxLinkDepth, l.path, l.binarypkg)}
+	}
+
+	l.state = linkResolving
+	err := r.link(l, depth)
+	if err != nil {
+		l.state = linkFailed
+		l.resolveErr = err
+		return err
+	}
+	l.state = linkResolved
+	return nil
+}
+
+func (r *linkResolver) link(l *manLinks, depth int) error {
+	m, err := manpageFromPath(r.gv.manPaths[r.product], l.source, nil)
+	if err != nil {
+		kind := problemKind(l.err)
+		if kind == problemOther {
+			kind = problemInvalidPath
+		}
+		addProblem(r.gv, l.pkg, l.path, kind,
+			fmt.Sprintf("%v: %v", l.err, err), resolutionSkipped)
+		return err
+	}
+
+	file, ok := r.gv.files[r.product][decompress.TrimSuffix(l.source)]
+	if !ok || (file.pkg == l.pkg && file.path == l.path) {
+		// not part of any other package, search a manpage
+		// with the same name
+		return r.guess(l, m)
+	}
+
+	if err := r.linkFile(l, file, depth); err != nil {
+		kind := problemKind(err)
+		if kind == problemOther {
+			kind = problemKind(l.err)
+		}
+		addProblem(r.gv, l.pkg, l.path, kind,
+			fmt.Sprintf("%v: %v", l.err, err), resolutionRemoved)
+		deleteXref(l.pkg, l.man, r.gv)
+		return err
+	}
+	return nil
+}
+
+// linkFile links l to the manpage file of another package.
+func (r *linkResolver) linkFile(l *manLinks, file packageFile, depth int) error {
+	target, err := manpageFromPath(r.gv.manPaths[r.product], file.path, file.pkg)
+	if err != nil {
+		return err
+	}
+	srcf := filepath.Join(r.servingDir, target.RawPath())
+	if _, err := os.Lstat(srcf); err != nil {
+		next, ok := r.pending[file]
+		if !ok {
+			return fmt.Errorf("%q of %s was not extracted", file.path, file.pkg.Binarypkg)
+		}
+		if err := r.resolve(next, depth+1); err != nil {
+			return err
+		}
+	}
+	if err := os.Link(srcf, l.target); err != nil {
+		return err
+	}
+	recordManpage(r.servingDir, l.target, l.pkg, r.gv)
+	recordAlias(l.pkg, l.man, target, r.gv)
+	return nil
+}
+
+// guess links l to a manpage of another package with the same name,
+// section and language as the missing file m.
+func (r *linkResolver) guess(l *manLinks, m *manpage.Meta) error {
+	gv := r.gv
+	todelete := -1
+
+	found := ""
+	x := gv.xref[m.Name]
+	for j := range x {
+		if r.product == x[j].Package.Product && m.Section == x[j].Section && m.Language == x[j].Language {
+			srcf := filepath.Join(r.servingDir, x[j].RawPath())
+			err := os.Link(srcf, l.target)
+			if err != nil {
+				todelete = j
+				continue
+			}
+			recordManpage(r.servingDir, l.target, l.pkg, gv)
+			found = x[j].RawPath()
+			break
+		}
+	}
+
+	// second run, relax m.Section and also allow substring matches (e.g. postgresql14-docs, where the .so reference got not adjusted
+	if found == "" {
+		for j := range x {
+			if r.product == x[j].Package.Product && strings.HasPrefix(x[j].Section, m.Section) && m.Language == x[j].Language {
+				srcf := filepath.Join(r.servingDir, x[j].RawPath())
+				err := os.Link(srcf, l.target)
+				if err != nil {
+					continue
+				}
+				recordManpage(r.servingDir, l.target, l.pkg, gv)
+				found = x[j].RawPath()
+				break
+			}
+		}
+	}
+
+	if found != "" {
+		addProblem(gv, l.pkg, l.path, problemKind(l.err),
+			l.err.Error(), fmt.Sprintf(resolutionLinkedFmt, found))
+		return nil
+	}
+
+	// No we really didn't found it.
+	addProblem(gv, l.pkg, l.path, problemKind(l.err),
+		l.err.Error(), resolutionRemoved)
+	if todelete >= 0 && !l.pkg.Older {
+		gv.xref[m.Name] = slices.Delete(gv.xref[m.Name], todelete, todelete+1)
+	} else {
+		deleteXref(l.pkg, l.man, gv)
+	}
+	return l.err
+}
+
+// recordAlias remembers that the manpage m of pkg is a link to target.
+func recordAlias(pkg *manpage.PkgMeta, m *manpage.Meta, target *manpage.Meta, gv *globalView) {
+	alias := *m
+	alias.Package = pkg
+	if pkg.Aliases == nil {
+		pkg.Aliases = make(map[string]string)
+	}
+	pkg.Aliases[alias.ServingPath()] = target.ServingPath()
+
+	if rs := gv.state.product(pkg.Product).RPMs[pkg.Filename]; rs != nil {
+		rs.Aliases = pkg.Aliases
+	}
+}
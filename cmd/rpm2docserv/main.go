@@ -15,32 +15,100 @@ import (
 
         "github.com/thkukuk/rpm2docserv/pkg/bundled"
         "github.com/thkukuk/rpm2docserv/pkg/commontmpl"
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
 	"github.com/thkukuk/rpm2docserv/pkg/write"
 )
 
 type Product struct {
 	Name     string   `yaml:"name"`
 	Cache    []string `yaml:"cache,omitempty"`
+	Repos    []string `yaml:"repos,omitempty"`
+	ManPaths []string `yaml:"manpaths,omitempty"`
+	Arch     []string `yaml:"arch,omitempty"`
 	Packages []string `yaml:"packages,omitempty"`
 	Alias    []string `yaml:"alias,omitempty"`
 	NoRender bool     `yaml:"norender"`
+	// Use the packages installed in Root instead of a cache
+	Installed bool   `yaml:"installed,omitempty"`
+	Root      string `yaml:"root,omitempty"`
+	// Manpages which are not packaged at all
+	Directory *DirectorySource `yaml:"directory,omitempty"`
+	// Number of older versions of each package to publish
+	KeepVersions int `yaml:"keep_versions,omitempty"`
+	// Armored OpenPGP public keys to verify the RPMs with
+	GPGKeys []string `yaml:"gpgkeys,omitempty"`
+
+	// The policy does not change the result for valid packages
+	SignaturePolicy string `yaml:"signature_policy,omitempty" json:"-"`
+	// Packages which may fail without failing the run
+	ErrorBudget string `yaml:"error_budget,omitempty" json:"-"`
+
+	// Changing the ignore rules does not require a rebuild
+	IgnoreExtractErrors []IgnoreRule `yaml:"ignore_extract_errors,omitempty" json:"-"`
+	IgnoreLinkErrors    []IgnoreRule `yaml:"ignore_link_errors,omitempty" json:"-"`
+}
+
+// DirectorySource is a directory tree with the layout of a man
+// hierarchy (man1/, de/man8/, ...), whose manpages are not part of any
+// package.
+type DirectorySource struct {
+	Path string `yaml:"path"`
+	// Package name for all manpages without an entry in Mapping
+	Package string `yaml:"package,omitempty"`
+	// File with lines "<pattern> <package>", the shell pattern is
+	// matched against the path relative to Path and its directories
+	Mapping string `yaml:"mapping,omitempty"`
+	Version string `yaml:"version,omitempty"`
 }
 
 type Config struct {
-	ProjectName string    `yaml:"projectname,omitempty"`
-	ProjectUrl  string    `yaml:"projecturl,omitempty"`
-	LogoUrl     string    `yaml:"logourl,omitempty"`
-	AssetsDir   string    `yaml:"assets,omitempty"`
-	ServingDir  string    `yaml:"servingdir"`
-	IndexPath   string    `yaml:"auxindex"`
-	Download    string    `yaml:"download"`
-	IsOffline   bool      `yaml:"offline,omitempty"`
-	BaseUrl     string    `yaml:"baseurl,omitempty"`
-	Products    []Product `yaml:"products"`
-	SortOrder   []string  `yaml:"sortorder,omitempty"`
-	ImportIdx   string    `yaml:"import,omitempty"`
+	ProjectName    string    `yaml:"projectname,omitempty"`
+	ProjectUrl     string    `yaml:"projecturl,omitempty"`
+	LogoUrl        string    `yaml:"logourl,omitempty"`
+	AssetsDir      string    `yaml:"assets,omitempty"`
+	ServingDir     string    `yaml:"servingdir"`
+	IndexPath      string    `yaml:"auxindex"`
+	Download       string    `yaml:"download"`
+	IsOffline      bool      `yaml:"offline,omitempty"`
+	BaseUrl        string    `yaml:"baseurl,omitempty"`
+	Products       []Product `yaml:"products"`
+	SortOrder      []string  `yaml:"sortorder,omitempty"`
+	ImportIdx      string    `yaml:"import,omitempty"`
+	RawCompression string    `yaml:"rawcompression,omitempty"`
+	// Default for all products: "skip" or "fail"
+	SignaturePolicy string `yaml:"signature_policy,omitempty"`
+	// Formats to render besides HTML and plain text: "pdf",
+	// "markdown"
+	Formats []string `yaml:"formats,omitempty"`
+	// Binary packages whose manpages are rendered with groff
+	// instead of mandoc
+	GroffPackages []string `yaml:"groff_packages,omitempty"`
+	// Default for all products: number ("10") or percentage ("1%")
+	// of the packages, which may fail without failing the run.
+	// Unlimited if not set.
+	ErrorBudget string `yaml:"error_budget,omitempty"`
+
+	IgnoreExtractErrors []IgnoreRule `yaml:"ignore_extract_errors,omitempty"`
+	IgnoreLinkErrors    []IgnoreRule `yaml:"ignore_link_errors,omitempty"`
+
+	// Number of older generations of each product to keep for a
+	// rollback, 1 if not set
+	KeepGenerations *int `yaml:"keep_generations,omitempty"`
+	// Maximal percentage of the pages a product may lose in a run,
+	// 0 for no limit
+	MaxShrink int `yaml:"max_shrink,omitempty"`
+	// Send SIGHUP to docserv-auxserver after publishing
+	ReloadAuxserver bool `yaml:"reload_auxserver,omitempty"`
 }
 
+var (
+	keepGenerations = 1
+	maxShrink       int
+	reloadAuxserver bool
+	groffPackages   = make(map[string]bool)
+)
+
 var (
 	servingDir = flag.String("serving-dir",
 		"/srv/docserv",
@@ -88,17 +156,27 @@ var (
 // use go build -ldflags "-X main.rpm2docservVersion=<version>" to set the version
 var rpm2docservVersion = "HEAD"
 
-func logic(products []Product) error {
+// logic returns true if the result was published despite errors
+// within the error budgets.
+func logic(products []Product) (bool, error) {
 	start := time.Now()
+	errs := newRunErrors()
 
 	// Stage 1: Download specified packages and their dependencies
 	// we don't do this if we have more than one cache directory.
+	// If rpm-md repositories are configured, only the packages with
+	// manual pages are downloaded from them and zypper is not used.
 	if !*noDownload {
-		if len(products) == 1 && len(products[0].Cache) == 1 {
+		if hasRemoteRepos(products) {
+			log.Printf("Downloading RPMs from repositories...\n")
+			if err := fetchRepos(products, errs); err != nil {
+				return false, fmt.Errorf("downloading packages: %v", err)
+			}
+		} else if len(products) == 1 && len(products[0].Cache) == 1 {
 			log.Printf("Downloading RPMs...\n");
 			err := zypperDownload(products[0].Packages, products[0].Cache[0], start)
 			if err != nil {
-				return fmt.Errorf("downloading packages: %v", err)
+				return false, fmt.Errorf("downloading packages: %v", err)
 			}
 		} else {
 			log.Printf("Downloading RPMs... - skipped, more than one suite or cache directory specified")
@@ -108,24 +186,38 @@ func logic(products []Product) error {
 
 	/* Stage 2: build globalView.pkgs by reading from disk */
 	log.Printf("Gathering all packages...\n");
-	globalView, err := buildGlobalView (products, start)
+	lastState := loadBuildState(*servingDir)
+	stage, err := newStaging(*servingDir, products, lastState)
+	if err != nil {
+		return false, fmt.Errorf("creating staging directory: %v", err)
+	}
+	buildDir = stage.dir
+
+	globalView, err := buildGlobalView (products, lastState, errs, start)
+	if err != nil {
+		return false, fmt.Errorf("gathering packages: %v", err)
+	}
+	for product, gen := range stage.generations {
+		globalView.state.product(product).Generation = gen
+	}
 	log.Printf("Gathered all packages, total %d packages", len(globalView.pkgs))
 
 	if len(importIdx) > 0 {
 		err := importIndex (importIdx, &globalView)
 		if err != nil {
-			return fmt.Errorf("importing index: %v", err)
+			return false, fmt.Errorf("importing index: %v", err)
 		}
 	}
 
 	stage3 := time.Now()
 
 	// Stage 3: Extract manual pages from packages and rename them
-	err = extractManpagesAll(*cacheDir, *servingDir, &globalView)
+	err = extractManpagesAll(*cacheDir, buildDir, &globalView)
 	if err != nil {
-		return fmt.Errorf("extracing manual pages: %v", err)
+		return false, fmt.Errorf("extracing manual pages: %v", err)
 	}
 	log.Printf("Extracted all manpages")
+	ignores.report()
 
 	stage4 := time.Now()
 
@@ -134,20 +226,46 @@ func logic(products []Product) error {
 	// using mandoc(1), directory index files are rendered, contents
 	// files are rendered.
 	if err := renderAll(&globalView); err != nil {
-		return fmt.Errorf("rendering manpages: %v", err)
+		return false, fmt.Errorf("rendering manpages: %v", err)
 	}
+	removeStalePages(buildDir, &globalView)
 
 	stage5 := time.Now()
 
 	// Stage 5: write the index after all rendering is complete.
 	path := strings.Replace(*indexPath, "<serving_dir>", *servingDir, -1)
 	log.Printf("Writing docserv-auxserver index to %q", path)
-	if err := writeIndex(path, &globalView); err != nil {
-		return fmt.Errorf("writing index: %v", err)
+	if err := writeIndex(filepath.Join(buildDir, stagingIndex), &globalView); err != nil {
+		return false, fmt.Errorf("writing index: %v", err)
+	}
+
+	if err := renderAux(buildDir, &globalView); err != nil {
+		return false, fmt.Errorf("rendering aux files: %v", err)
+	}
+
+	if err := writeProblems(buildDir, &globalView); err != nil {
+		return false, err
 	}
 
-	if err := renderAux(*servingDir, &globalView); err != nil {
-		return fmt.Errorf("rendering aux files: %v", err)
+	if err := globalView.state.save(buildDir); err != nil {
+		return false, fmt.Errorf("writing build state: %v", err)
+	}
+
+	// Stage 6: switch over to the new generation of all products,
+	// if it looks sane. Otherwise the staging directory is kept for
+	// inspection until the next run.
+	if err := globalView.errors.checkBudgets(&globalView); err != nil {
+		printErrors(globalView.errors)
+		return false, fmt.Errorf("not publishing %q: %v", buildDir, err)
+	}
+	if err := stage.check(&globalView, maxShrink); err != nil {
+		return false, fmt.Errorf("not publishing %q: %v", buildDir, err)
+	}
+	if err := stage.publish(path, keepGenerations); err != nil {
+		return false, fmt.Errorf("publishing: %v", err)
+	}
+	if reloadAuxserver {
+		signalAuxserver()
 	}
 
 	finish := time.Now()
@@ -155,8 +273,12 @@ func logic(products []Product) error {
 	fmt.Printf("total number of packages: %d\n", globalView.stats.TotalNumberPkgs)
 	fmt.Printf("packages with manpages:   %d\n", globalView.stats.PackagesExtracted)
 	fmt.Printf("manpages rendered:        %d\n", globalView.stats.ManpagesRendered)
+	fmt.Printf("manpages unchanged:       %d\n", globalView.stats.ManpagesUnchanged)
 	fmt.Printf("total manpage bytes:      %d\n", globalView.stats.ManpageBytes)
 	fmt.Printf("total HTML bytes:         %d\n", globalView.stats.HTMLBytes)
+	for _, f := range formats {
+		fmt.Printf("%-26s%d\n", fmt.Sprintf("total %s bytes:", f.Name), *globalView.stats.FormatBytes[f.Name])
+	}
 	fmt.Printf("auxserver index bytes:    %d\n", globalView.stats.IndexBytes)
 	fmt.Printf("download packages (s):    %d\n", int(stage2.Sub(start).Seconds()))
 	fmt.Printf("gather all packages (s):  %d\n", int(stage3.Sub(stage2).Seconds()))
@@ -164,13 +286,15 @@ func logic(products []Product) error {
 	fmt.Printf("render all manpages (s):  %d\n", int(stage5.Sub(stage4).Seconds()))
 	fmt.Printf("write index (s):          %d\n", int(finish.Sub(stage5).Seconds()))
 	fmt.Printf("wall-clock runtime (s):   %d\n", int(finish.Sub(start).Seconds()))
+	printErrors(globalView.errors)
 
-	return write.Atomically(filepath.Join(*servingDir, "metrics.txt"), false, func(w io.Writer) error {
+	err = write.Atomically(filepath.Join(*servingDir, "metrics.txt"), false, func(w io.Writer) error {
 		if err := writeMetrics(w, &globalView, start); err != nil {
 			return fmt.Errorf("writing metrics: %v", err)
 		}
 		return nil
 	})
+	return globalView.errors.total() > 0, err
 }
 
 func read_yaml_config(conffile string) (Config, error) {
@@ -189,12 +313,31 @@ func read_yaml_config(conffile string) (Config, error) {
 	return config, nil
 }
 
+// usage prints the flags and the exit codes of rpm2docserv.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nExit codes:\n")
+	fmt.Fprintf(out, "  0\tpublished without errors\n")
+	fmt.Fprintf(out, "  1\tfailed, nothing was published\n")
+	fmt.Fprintf(out, "  %d\tpublished, but with errors within the error budgets\n", exitWarnings)
+}
+
 func main() {
+	var config Config
 	var products []Product
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	flag.Usage = usage
 	flag.Parse()
+	if *downloadConcurrency < 1 {
+		log.Fatalf("Invalid value %d for flag -concurrency_download, must be at least 1", *downloadConcurrency)
+	}
+	if *scanConcurrency < 1 {
+		log.Fatalf("Invalid value %d for flag -concurrency_scan, must be at least 1", *scanConcurrency)
+	}
 
 	if *showVersion || *verbose {
 		fmt.Printf("rpm2docserv %s\n", rpm2docservVersion)
@@ -204,7 +347,8 @@ func main() {
 	}
 
 	if len(*yamlConfig) > 0 {
-		config, err := read_yaml_config(*yamlConfig)
+		var err error
+		config, err = read_yaml_config(*yamlConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -223,8 +367,8 @@ func main() {
 			} else if strings.EqualFold(config.Download, "true") {
 				*noDownload = false
 			} else {
-				log.Fatal("Invalid value %q for option \"download\" in config %q",
-					config.Download, yamlConfig)
+				log.Fatalf("Invalid value %q for option \"download\" in config %q",
+					config.Download, *yamlConfig)
 			}
 		}
 		if len(config.SortOrder) > 0 {
@@ -233,12 +377,33 @@ func main() {
 			}
 		}
 
+		switch config.RawCompression {
+		case "":
+		case decompress.Gzip, decompress.Xz, decompress.Zstd:
+			manpage.RawSuffix = decompress.Suffix(config.RawCompression)
+		default:
+			log.Fatalf("Invalid value %q for option \"rawcompression\" in config %q",
+				config.RawCompression, *yamlConfig)
+		}
+
 		isOffline = config.IsOffline
 		projectName = config.ProjectName
 		projectUrl = config.ProjectUrl
 		logoUrl = config.LogoUrl
 		products = config.Products
 		importIdx = config.ImportIdx
+		if config.KeepGenerations != nil {
+			if *config.KeepGenerations < 0 {
+				log.Fatalf("Invalid value %d for option \"keep_generations\" in config %q",
+					*config.KeepGenerations, *yamlConfig)
+			}
+			keepGenerations = *config.KeepGenerations
+		}
+		maxShrink = config.MaxShrink
+		reloadAuxserver = config.ReloadAuxserver
+		for _, pkg := range config.GroffPackages {
+			groffPackages[pkg] = true
+		}
 	} else {
 		products = make([]Product, 1)
 		products[0].Name = "manpages"
@@ -246,6 +411,19 @@ func main() {
 		products[0].Packages = strings.Split(*pkg2Render, ",")
 	}
 
+	if err := setupIgnoreRules(config, products); err != nil {
+		log.Fatalf("Invalid ignore rule in config %q: %v", *yamlConfig, err)
+	}
+	if err := setupSignaturePolicy(config, products); err != nil {
+		log.Fatalf("Invalid config %q: %v", *yamlConfig, err)
+	}
+	if err := setupErrorBudgets(config, products); err != nil {
+		log.Fatalf("Invalid config %q: %v", *yamlConfig, err)
+	}
+	if err := setupFormats(config); err != nil {
+		log.Fatalf("Invalid config %q: %v", *yamlConfig, err)
+	}
+
 
 	if *injectAssets != "" {
 		if err := bundled.Inject(*injectAssets); err != nil {
@@ -261,6 +439,7 @@ func main() {
 		manpageTmpl = mustParseManpageTmpl()
 		manpageerrorTmpl = mustParseManpageerrorTmpl()
 		manpagefooterextraTmpl = mustParseManpagefooterextraTmpl()
+		problemsTmpl = mustParseProblemsTmpl()
 	}
 
 	// make sure the serving directory exists
@@ -268,7 +447,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if err := logic(products); err != nil {
+	warnings, err := logic(products)
+	if err != nil {
 		log.Fatal(err)
 	}
+	if warnings {
+		log.Printf("Published with errors")
+		os.Exit(exitWarnings)
+	}
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"github.com/thkukuk/rpm2docserv/pkg/bundled"
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
+	"github.com/thkukuk/rpm2docserv/pkg/write"
+)
+
+// Kinds of problems found while extracting the manual pages
+const (
+	problemDanglingSymlink = "dangling-symlink"
+	problemSelfReference   = "self-reference"
+	problemMissingTarget   = "missing-target"
+	problemOutsideRoot     = "outside-root"
+	problemInvalidPath     = "invalid-path"
+	problemLinkCycle       = "link-cycle"
+	problemLinkDepth       = "link-depth"
+	problemExtract         = "extract"
+	problemInstall         = "install"
+	problemOther           = "error"
+)
+
+// Resolutions of problems
+const (
+	resolutionKept      = "kept as is"
+	resolutionSkipped   = "skipped"
+	resolutionRemoved   = "removed from cross references"
+	resolutionIgnored   = "ignored by configuration"
+	resolutionLinkedFmt = "linked to %s"
+)
+
+// Problem is an issue with a manual page of a package, which packagers
+// should fix.
+type Problem struct {
+	Product   string `json:"product"`
+	Binarypkg string `json:"binarypkg"`
+	Sourcepkg string `json:"sourcepkg"`
+	// Path is the manual page inside the package
+	Path string `json:"path"`
+	// Kind is one of the problem* constants
+	Kind       string `json:"kind"`
+	Reason     string `json:"reason"`
+	Resolution string `json:"resolution"`
+}
+
+// refError is returned by getManpageRef, so that the kind of the
+// problem is known.
+type refError struct {
+	kind string
+	err  error
+}
+
+func (e *refError) Error() string {
+	return e.err.Error()
+}
+
+func (e *refError) Unwrap() error {
+	return e.err
+}
+
+func problemKind(err error) string {
+	var re *refError
+	if errors.As(err, &re) {
+		return re.kind
+	}
+	return problemOther
+}
+
+// addProblem logs the problem and records it in the build state of
+// the package, so that it is reported again if the package is not
+// extracted in the next run.
+func addProblem(gv *globalView, pkg *manpage.PkgMeta, path string, kind string, reason string, resolution string) {
+	p := Problem{
+		Product:    pkg.Product,
+		Binarypkg:  pkg.Binarypkg,
+		Sourcepkg:  pkg.Sourcepkg,
+		Path:       path,
+		Kind:       kind,
+		Reason:     reason,
+		Resolution: resolution,
+	}
+	log.Printf("%s/%s: %s: %s (%s)", p.Product, p.Binarypkg, p.Kind, p.Reason, p.Resolution)
+
+	gv.state.mu.Lock()
+	defer gv.state.mu.Unlock()
+	if ps, ok := gv.state.Products[pkg.Product]; ok {
+		if rs, ok := ps.RPMs[pkg.Filename]; ok {
+			rs.Problems = append(rs.Problems, p)
+		}
+	}
+}
+
+// allProblems returns the problems of all packages, sorted by product,
+// source package, binary package and path.
+func allProblems(gv *globalView) []Problem {
+	var problems []Problem
+	for _, pkg := range gv.pkgs {
+		ps, ok := gv.state.Products[pkg.Product]
+		if !ok {
+			continue
+		}
+		if rs, ok := ps.RPMs[pkg.Filename]; ok {
+			problems = append(problems, rs.Problems...)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.Product != b.Product {
+			return a.Product < b.Product
+		}
+		if a.Sourcepkg != b.Sourcepkg {
+			return a.Sourcepkg < b.Sourcepkg
+		}
+		if a.Binarypkg != b.Binarypkg {
+			return a.Binarypkg < b.Binarypkg
+		}
+		return a.Path < b.Path
+	})
+	return problems
+}
+
+var problemsTmpl = mustParseProblemsTmpl()
+
+func mustParseProblemsTmpl() *template.Template {
+	return template.Must(template.Must(commonTmpls.Clone()).New("problems").Parse(bundled.Asset("problems.tmpl")))
+}
+
+type problemGroup struct {
+	Product   string
+	Sourcepkg string
+	Problems  []Problem
+}
+
+// writeProblems writes problems.json and problems.html, which lists
+// the problems grouped by source package.
+func writeProblems(servingDir string, gv *globalView) error {
+	problems := allProblems(gv)
+
+	if err := write.Atomically(filepath.Join(servingDir, "problems.json"), false, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if problems == nil {
+			problems = []Problem{}
+		}
+		return enc.Encode(problems)
+	}); err != nil {
+		return fmt.Errorf("writing problems.json: %v", err)
+	}
+
+	var groups []problemGroup
+	for _, p := range problems {
+		if n := len(groups); n == 0 || groups[n-1].Product != p.Product || groups[n-1].Sourcepkg != p.Sourcepkg {
+			groups = append(groups, problemGroup{Product: p.Product, Sourcepkg: p.Sourcepkg})
+		}
+		groups[len(groups)-1].Problems = append(groups[len(groups)-1].Problems, p)
+	}
+
+	return write.Atomically(filepath.Join(servingDir, "problems.html"), false, func(w io.Writer) error {
+		return problemsTmpl.Execute(w, struct {
+			Title              string
+			ProjectName        string
+			ProjectUrl         string
+			LogoUrl            string
+			IsOffline          bool
+			Rpm2docservVersion string
+			Breadcrumbs        breadcrumbs
+			FooterExtra        string
+			Meta               *manpage.Meta
+			HrefLangs          []*manpage.Meta
+			Products           []string
+			Groups             []problemGroup
+			Total              int
+		}{
+			Title:              "Problems",
+			ProjectName:        projectName,
+			ProjectUrl:         projectUrl,
+			LogoUrl:            logoUrl,
+			IsOffline:          isOffline,
+			Rpm2docservVersion: rpm2docservVersion,
+			Breadcrumbs: breadcrumbs{
+				{"", "Problems"},
+			},
+			Products: gv.productList,
+			Groups:   groups,
+			Total:    len(problems),
+		})
+	})
+}
@@ -15,19 +15,36 @@ rpm2docserv_packages_total {{ .Packages }}
 # TYPE rpm2docserv_packages_extracted gauge
 rpm2docserv_packages_extracted {{ .Stats.PackagesExtracted }}
 
+# HELP rpm2docserv_signatures Number of binary packages with manpages by result of the signature verification.
+# TYPE rpm2docserv_signatures gauge
+rpm2docserv_signatures{result="valid"} {{ .Stats.SignaturesValid }}
+rpm2docserv_signatures{result="unsigned"} {{ .Stats.SignaturesUnsigned }}
+rpm2docserv_signatures{result="bad"} {{ .Stats.SignaturesBad }}
+
 # HELP rpm2docserv_manpages_rendered Number of manpages rendered to HTML
 # TYPE rpm2docserv_manpages_rendered gauge
 rpm2docserv_manpages_rendered {{ .Stats.ManpagesRendered }}
 
+# HELP rpm2docserv_manpages_unchanged Number of manpages not rendered again, since nothing changed since the last run
+# TYPE rpm2docserv_manpages_unchanged gauge
+rpm2docserv_manpages_unchanged {{ .Stats.ManpagesUnchanged }}
+
 # HELP rpm2docserv_manpage_bytes Total number of bytes used by manpages (by format).
 # TYPE rpm2docserv_manpage_bytes gauge
 rpm2docserv_manpage_bytes{format="man"} {{ .Stats.ManpageBytes }}
 rpm2docserv_manpage_bytes{format="html"} {{ .Stats.HTMLBytes }}
-
+{{ range .Formats -}}
+rpm2docserv_manpage_bytes{format="{{ .Name }}"} {{ .Bytes }}
+{{ end }}
 # HELP rpm2docserv_index_bytes Total number of bytes used for the auxserver index.
 # TYPE rpm2docserv_index_bytes gauge
 rpm2docserv_index_bytes {{ .Stats.IndexBytes }}
 
+# HELP rpm2docserv_errors Number of errors by product and class, which did not fail the run.
+# TYPE rpm2docserv_errors gauge
+{{ range .Errors -}}
+rpm2docserv_errors{product="{{ .Product }}",class="{{ .Class }}"} {{ .Count }}
+{{ end }}
 # HELP rpm2docserv_runtime Wall-clock runtime in seconds.
 # TYPE rpm2docserv_runtime gauge
 rpm2docserv_runtime {{ .Seconds }}
@@ -44,14 +61,31 @@ func writeMetrics(w io.Writer, gv *globalView, start time.Time) error {
 	return metricsTmpl.Execute(w, struct {
 		Packages          int
 		Stats             *stats
+		Formats           []formatBytes
+		Errors            []errorCount
 		Now               time.Time
 		Seconds           int
 		LastSuccessfulRun int64
 	}{
 		Packages:          len(gv.pkgs),
 		Stats:             gv.stats,
+		Formats:           listFormatBytes(gv.stats),
+		Errors:            gv.errors.list(),
 		Now:               now,
 		Seconds:           int(now.Sub(start).Seconds()),
 		LastSuccessfulRun: now.Unix(),
 	})
 }
+
+type formatBytes struct {
+	Name  string
+	Bytes uint64
+}
+
+func listFormatBytes(s *stats) []formatBytes {
+	result := make([]formatBytes, 0, len(formats))
+	for _, f := range formats {
+		result = append(result, formatBytes{f.Name, *s.FormatBytes[f.Name]})
+	}
+	return result
+}
@@ -85,13 +85,14 @@ func (b breadcrumbs) ToJSON() template.JS {
 var commonTmpls = commontmpl.MustParseCommonTmpls()
 
 // listManpages lists all files in dir (non-recursively) and returns a map from
-// filename (within dir) to *manpage.Meta.
-func listManpages(product string, pkg string, gv *globalView) (map[string]*manpage.Meta, error) {
+// filename (within dir) to *manpage.Meta. dir is the name of the binary
+// package or, for older versions, <binarypkg>@<version>.
+func listManpages(product string, dir string, gv *globalView) (map[string]*manpage.Meta, error) {
 	manpageByName := make(map[string]*manpage.Meta)
 
-	for _, x := range gv.xref {
-                for _, m := range x {
-			if m.Package.Product == product && m.Package.Binarypkg == pkg {
+	for _, x := range manpageLists(gv) {
+		for _, m := range x {
+			if m.Package.Product == product && m.Package.Dir() == dir {
 				manpageByName[m.Name+"."+m.Section+"."+m.Language] = m
 			}
 		}
@@ -99,17 +100,30 @@ func listManpages(product string, pkg string, gv *globalView) (map[string]*manpa
 	return manpageByName, nil
 }
 
+// manpageLists returns the lists of manpages with the same name of the
+// cross reference index and of the older versions.
+func manpageLists(gv *globalView) [][]*manpage.Meta {
+	lists := make([][]*manpage.Meta, 0, len(gv.xref)+len(gv.older))
+	for _, x := range gv.xref {
+		lists = append(lists, x)
+	}
+	for _, x := range gv.older {
+		lists = append(lists, x)
+	}
+	return lists
+}
+
 // walkManContents walks over all entries in dir and send a renderJob for each file
 func walkManContents(ctx context.Context, renderChan chan<- renderJob, product string, pkg string, gv *globalView) error {
 
-	for _, x := range gv.xref {
+	for _, x := range manpageLists(gv) {
                 for _, m := range x {
-			if m.Package.Product != product || m.Package.Binarypkg != pkg {
+			if m.Package.Product != product || m.Package.Dir() != pkg {
 				continue
 			}
 
-			fn := m.Name+"."+m.Section+"."+m.Language+".gz"
-			full := filepath.Join(*servingDir, product, pkg, fn)
+			fn := m.Name+"."+m.Section+"."+m.Language+manpage.RawSuffix
+			full := filepath.Join(buildDir, product, pkg, fn)
 
 			st, err := os.Lstat(full)
 			if err != nil {
@@ -129,13 +143,14 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, product s
 				}
 			}
 
-			n := strings.TrimSuffix(full, ".gz") + ".html.gz"
+			n := strings.TrimSuffix(full, manpage.RawSuffix) + ".html.gz"
 			select {
 				case renderChan <- renderJob{
 					dest:     n,
 					src:      full,
 					meta:     m,
 					versions: versions,
+					older:    gv.older[m.Name],
 					xref:     gv.xref,
 					modTime:  st.ModTime(),
 				}:
@@ -163,7 +178,7 @@ func walkProductContents(ctx context.Context, renderChan chan<- renderJob, produ
 
 			// and finally render the package index files
 			if err := writeBinaryPkgIndex(product, pkg, gv); err != nil {
-				return err
+				gv.errors.add(product, errorIndex, pkg, fmt.Errorf("writing index of %s: %v", pkg, err))
 			}
 
 			return nil
@@ -186,51 +201,54 @@ func writeBinaryPkgIndex(product string, binarypkg string, gv *globalView) error
 
 	if len(manpageByName) == 0 {
 		log.Printf("WARNING: empty directory %s/%s/%s, not generating package index",
-			*servingDir, product, binarypkg)
+			buildDir, product, binarypkg)
 		return nil
 	}
 
-	return renderPkgIndex(filepath.Join(*servingDir, product, binarypkg, "index.html"), manpageByName, gv)
+	return renderPkgIndex(filepath.Join(buildDir, product, binarypkg, "index.html"), manpageByName, gv)
 }
 
 // This function creates the index.html for product/src:package where the
 // manpage links point to the manual pages in the binary package directory
-func writeSourcePkgIndex(product string, gv *globalView) error {
+func writeSourcePkgIndex(product string, gv *globalView) {
 	// Partition by product for reduced memory usage and better locality of file
 	// system access
 	binariesBySource := make(map[string][]string)
 	for _, pkg := range gv.pkgs {
-		if pkg.Product == product {
+		if pkg.Product == product && !pkg.Older {
 			binariesBySource[pkg.Sourcepkg] = append(binariesBySource[pkg.Sourcepkg], pkg.Binarypkg)
 		}
 	}
 
 	for src, binaries := range binariesBySource {
-		srcDir := filepath.Join(*servingDir, product, "src:"+src)
+		srcDir := filepath.Join(buildDir, product, "src:"+src)
 
 		// Aggregate manpages of all binary packages for this source package
 		manpages := make(map[string]*manpage.Meta)
+		var err error
 		for _, binary := range binaries {
-			m, err := listManpages(product, binary, gv)
-			if err != nil {
-				return err
+			var m map[string]*manpage.Meta
+			if m, err = listManpages(product, binary, gv); err != nil {
+				break
 			}
 			for k, v := range m {
 				manpages[k] = v
 			}
 		}
-		if len(manpages) == 0 {
+		if err == nil && len(manpages) == 0 {
 			continue // The entire source package does not contain any manpages.
 		}
 
-		if err := os.MkdirAll(srcDir, 0755); err != nil {
-			return err
+		if err == nil {
+			err = os.MkdirAll(srcDir, 0755)
 		}
-		if err := renderSrcPkgIndex(filepath.Join(srcDir, "index.html"), src, manpages, gv); err != nil {
-			return err
+		if err == nil {
+			err = renderSrcPkgIndex(filepath.Join(srcDir, "index.html"), src, manpages, gv)
+		}
+		if err != nil {
+			gv.errors.add(product, errorIndex, "src:"+src, fmt.Errorf("writing source index of %s: %v", src, err))
 		}
 	}
-	return nil
 }
 
 func renderAll(gv *globalView) error {
@@ -249,12 +267,21 @@ func renderAll(gv *globalView) error {
 			}
 
 			for r := range renderChan {
+				if pageUpToDate(r, gv) {
+					atomic.AddUint64(&gv.stats.ManpagesUnchanged, 1)
+					continue
+				}
+
 				n, err := rendermanpage(gzipw, r, gv)
 				if err != nil {
 					// rendermanpage writes an error page if rendering
 					// failed, any returned error is severe (e.g. file
-					// system full) and should lead to termination.
-					return err
+					// system full). The page of the last run is kept,
+					// the error budget decides whether to publish.
+					gv.errors.add(r.meta.Package.Product, errorRender, r.meta.Package.Filename,
+						fmt.Errorf("rendering %s: %v", relServingPath(r.dest), err))
+					keepLastPage(r, gv)
+					continue
 				}
 
 				atomic.AddUint64(&gv.stats.HTMLBytes, n)
@@ -283,6 +310,20 @@ func renderAll(gv *globalView) error {
 			}
 		}
 
+		// The older versions are rendered, but not listed in the
+		// contents of the product
+		b_olderdirs := make(map[string]bool)
+		for _, x := range gv.older {
+			for _, m := range x {
+				if product == m.Package.Product {
+					b_olderdirs[m.Package.Dir()] = true
+				}
+			}
+		}
+
+		// Packages from the last run, which don't exist anymore
+		removeStaleDirs(filepath.Join(buildDir, product), b_pkgdirs, b_srcpkgdirs, b_olderdirs)
+
 		pkgdirs := make([]string, 0, len(b_pkgdirs))
 		srcpkgdirs := make([]string, 0, len(b_srcpkgdirs))
 
@@ -300,16 +341,20 @@ func renderAll(gv *globalView) error {
 			continue
 		}
 
-		if err := walkProductContents(ctx, renderChan, product, pkgdirs, gv); err != nil {
-			return err
+		olderdirs := make([]string, 0, len(b_olderdirs))
+		for e := range b_olderdirs {
+			olderdirs = append(olderdirs, e)
 		}
+		sort.Strings(olderdirs)
 
-		if err := writeSourcePkgIndex(product, gv); err != nil {
-			return fmt.Errorf("writing source index for %s: %v", product, err)
+		if err := walkProductContents(ctx, renderChan, product, append(pkgdirs, olderdirs...), gv); err != nil {
+			return err
 		}
 
-		if err := renderProductContents(filepath.Join(*servingDir, product, "index.html",), product, pkgdirs, srcpkgdirs, gv); err != nil {
-			return err
+		writeSourcePkgIndex(product, gv)
+
+		if err := renderProductContents(filepath.Join(buildDir, product, "index.html",), product, pkgdirs, srcpkgdirs, gv); err != nil {
+			gv.errors.add(product, errorIndex, "index.html", fmt.Errorf("writing contents of %s: %v", product, err))
 		}
 	}
 
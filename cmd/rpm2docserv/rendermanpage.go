@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
@@ -11,13 +13,16 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/thkukuk/rpm2docserv/pkg/bundled"
 	"github.com/thkukuk/rpm2docserv/pkg/commontmpl"
 	"github.com/thkukuk/rpm2docserv/pkg/convert"
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
 	"github.com/thkukuk/rpm2docserv/pkg/manpage"
 	"github.com/thkukuk/rpm2docserv/pkg/write"
 	"golang.org/x/text/language"
@@ -103,31 +108,36 @@ func mustParseManpagefooterextraTmpl() *template.Template {
 		Parse(bundled.Asset("manpagefooterextra.tmpl")))
 }
 
-func convertFile(src string, resolve func(ref string) string) (doc string, toc []string, err error) {
+// convertFile renders the manpage src to HTML, with groff instead of
+// mandoc if preferGroff is set. It returns the renderer used.
+func convertFile(src string, preferGroff bool, resolve func(ref string) string) (doc string, toc []string, renderer string, err error) {
 	f, err := os.Open(src)
 	if err != nil {
-		return "", nil, err
+		return "", nil, "", err
 	}
 	defer f.Close()
 
-	r := io.Reader(f)
-	gzipr, err := gzip.NewReader(f)
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		// TODO: better representation of an empty manpage
+		return "This space intentionally left blank.", nil, "", nil
+	}
+
+	r, _, err := decompress.Detect(f)
 	if err != nil {
-		if err == io.EOF {
-			// TODO: better representation of an empty manpage
-			return "This space intentionally left blank.", nil, nil
-		} else if err != gzip.ErrHeader {
-			return "", nil, err
-		}
-	} else {
-		r = gzipr
-		defer gzipr.Close()
+		return "", nil, "", err
 	}
-	out, toc, err := convert.ToHTML(r, resolve)
+	defer r.Close()
+	out, toc, renderer, err := convert.RenderHTML(r, resolve, preferGroff)
 	if err != nil {
-		return "", nil, fmt.Errorf("convert(%q): %v", src, err)
+		return "", nil, "", fmt.Errorf("convert(%q): %v", src, err)
 	}
-	return out, toc, nil
+	return out, toc, renderer, nil
+}
+
+// preferGroff returns true if the manpages of pkg are rendered with
+// groff, see Config.GroffPackages.
+func preferGroff(pkg *manpage.PkgMeta) bool {
+	return groffPackages[pkg.Binarypkg]
 }
 
 type byPkgAndLanguage struct {
@@ -184,31 +194,42 @@ type renderJob struct {
 	versions []*manpage.Meta
 	xref     map[string][]*manpage.Meta
 	modTime  time.Time
+
+	// older are the manpages with the same name of the older
+	// versions kept because of keep_versions
+	older []*manpage.Meta
+
+	// refs collects all cross references found while rendering
+	refs map[string]bool
 }
 
 var notYetRenderedSentinel = errors.New("Not yet rendered")
 
 type manpagePrepData struct {
-	Title          string
-	ProjectName    string
-	ProjectUrl     string
-	LogoUrl        string
-	IsOffline      bool
+	Title              string
+	ProjectName        string
+	ProjectUrl         string
+	LogoUrl            string
+	IsOffline          bool
 	Rpm2docservVersion string
-	Breadcrumbs    breadcrumbs
-	FooterExtra    template.HTML
-	AltVersions    []*manpage.Meta
-	Versions       []*manpage.Meta
-	Sections       []*manpage.Meta
-	Bins           []*manpage.Meta
-	Langs          []*manpage.Meta
-	HrefLangs      []*manpage.Meta
-	Meta           *manpage.Meta
-	TOC            []string
-	Ambiguous      map[*manpage.Meta]bool
-	Content        template.HTML
-	Error          error
-	Products       []string
+	Breadcrumbs        breadcrumbs
+	FooterExtra        template.HTML
+	AltVersions        []*manpage.Meta
+	Versions           []*manpage.Meta
+	Sections           []*manpage.Meta
+	Bins               []*manpage.Meta
+	Langs              []*manpage.Meta
+	HrefLangs          []*manpage.Meta
+	Meta               *manpage.Meta
+	TOC                []string
+	Ambiguous          map[*manpage.Meta]bool
+	Content            template.HTML
+	Error              error
+	Products           []string
+	// Formats are the formats rendered for this page besides HTML
+	Formats []*outputFormat
+	// Renderer is the program, which converted the page to HTML
+	Renderer string
 }
 
 type byProduct []*manpage.Meta
@@ -243,19 +264,11 @@ func (p byBinarypkg) Len() int           { return len(p) }
 func (p byBinarypkg) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p byBinarypkg) Less(i, j int) bool { return p[i].Package.Binarypkg < p[j].Package.Binarypkg }
 
-func rendermanpageprep(job renderJob, gv *globalView) (*template.Template, manpagePrepData, error) {
-	meta := job.meta // for convenience
-	// TODO(issue): document fundamental limitation: “other languages” is imprecise: e.g. crontab(1) — are the languages for package:systemd-cron or for package:cron?
-	// TODO(later): to boost confidence in detecting cross-references, can we add to testdata the entire list of man page names from debian to have a good test?
-	// TODO(later): add plain-text version
-
-	var (
-		content   string
-		toc       []string
-		renderErr = notYetRenderedSentinel
-	)
-
-	content, toc, renderErr = convertFile(job.src, func(ref string) string {
+// xrefResolver returns a function, which resolves a reference (like
+// "rm(1)") in the manpage of job into a URL.
+func xrefResolver(job renderJob) func(ref string) string {
+	meta := job.meta
+	return func(ref string) string {
 		idx := strings.LastIndex(ref, "(")
 		if idx == -1 {
 			return ""
@@ -280,17 +293,40 @@ func rendermanpageprep(job renderJob, gv *globalView) (*template.Template, manpa
 			return ""
 		}
 		return commontmpl.BaseURLPath() + "/" + bestLanguageMatch(meta, filtered).ServingPath() + ".html"
+	}
+}
+
+func rendermanpageprep(job renderJob, gv *globalView) (*template.Template, manpagePrepData, error) {
+	meta := job.meta // for convenience
+	// TODO(issue): document fundamental limitation: “other languages” is imprecise: e.g. crontab(1) — are the languages for package:systemd-cron or for package:cron?
+	// TODO(later): to boost confidence in detecting cross-references, can we add to testdata the entire list of man page names from debian to have a good test?
+
+	var (
+		content   string
+		toc       []string
+		renderer  string
+		renderErr = notYetRenderedSentinel
+	)
+
+	resolve := xrefResolver(job)
+	content, toc, renderer, renderErr = convertFile(job.src, preferGroff(meta.Package), func(ref string) string {
+		if job.refs != nil {
+			job.refs[ref] = true
+		}
+		return resolve(ref)
 	})
 	if renderErr != nil {
 		log.Printf("ERROR: Rendering %q failed: %q", job.dest, renderErr)
+	} else if renderer == convert.Groff && *verbose {
+		log.Printf("Rendered %q with groff", job.dest)
 	}
 
 	if *verbose {
 		log.Printf("rendering %q", job.dest)
 	}
 
-	altVersions := make([]*manpage.Meta, 0, len(job.versions))
-	for _, v := range job.versions {
+	altVersions := make([]*manpage.Meta, 0, len(job.versions)+len(job.older))
+	for _, v := range slices.Concat(job.versions, job.older) {
 		if !v.Package.SameBinary(meta.Package) {
 			continue
 		}
@@ -396,26 +432,28 @@ func rendermanpageprep(job renderJob, gv *globalView) (*template.Template, manpa
 		SourceFile  string
 		LastUpdated time.Time
 		Converted   time.Time
+		Renderer    string
 		Meta        *manpage.Meta
 	}{
 		SourceFile:  filepath.Base(job.src),
 		LastUpdated: job.modTime,
 		Converted:   time.Now(),
+		Renderer:    renderer,
 		Meta:        meta,
 	}); err != nil {
 		return nil, manpagePrepData{}, err
 	}
 
 	return t, manpagePrepData{
-		Title:          title,
-		ProjectName:    projectName,
-		ProjectUrl:     projectUrl,
-		LogoUrl:        logoUrl,
-		IsOffline:      isOffline,
+		Title:              title,
+		ProjectName:        projectName,
+		ProjectUrl:         projectUrl,
+		LogoUrl:            logoUrl,
+		IsOffline:          isOffline,
 		Rpm2docservVersion: rpm2docservVersion,
 		Breadcrumbs: breadcrumbs{
 			{fmt.Sprintf("/%s/index.html", meta.Package.Product), meta.Package.Product},
-			{fmt.Sprintf("/%s/%s/index.html", meta.Package.Product, meta.Package.Binarypkg), meta.Package.Binarypkg},
+			{fmt.Sprintf("/%s/%s/index.html", meta.Package.Product, meta.Package.Dir()), meta.Package.Dir()},
 			{"", shorttitle},
 		},
 		FooterExtra: template.HTML(footerExtra.String()),
@@ -431,6 +469,7 @@ func rendermanpageprep(job renderJob, gv *globalView) (*template.Template, manpa
 		Content:     template.HTML(content),
 		Error:       renderErr,
 		Products:    gv.productList,
+		Renderer:    renderer,
 	}, nil
 }
 
@@ -442,17 +481,95 @@ func (c *countingWriter) Write(p []byte) (n int, err error) {
 }
 
 func rendermanpage(gzipw *gzip.Writer, job renderJob, gv *globalView) (uint64, error) {
+	job.refs = make(map[string]bool)
 	t, data, err := rendermanpageprep(job, gv)
 	if err != nil {
 		return 0, err
 	}
 
+	// The page only links to the formats, which could be rendered
+	var rendered []string
+	for _, f := range formats {
+		n, ok, err := renderFormat(gzipw, job, f, gv)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			data.Formats = append(data.Formats, f)
+			rendered = append(rendered, f.Name)
+		}
+		atomic.AddUint64(gv.stats.FormatBytes[f.Name], n)
+	}
+
 	var written countingWriter
+	hash := sha256.New()
 	if err := write.AtomicallyWithGz(job.dest, gzipw, func(w io.Writer) error {
-		return t.Execute(io.MultiWriter(w, &written), data)
+		return t.Execute(io.MultiWriter(w, &written, hash), data)
 	}); err != nil {
 		return 0, err
 	}
 
+	refs := make([]string, 0, len(job.refs))
+	for ref := range job.refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	input, err := pageInput(job, refs, gv)
+	if err != nil {
+		return 0, err
+	}
+	gv.state.setPage(job.meta.Package.Product, relServingPath(job.dest), &pageState{
+		Input:    input,
+		Output:   hex.EncodeToString(hash.Sum(nil)),
+		Refs:     refs,
+		Renderer: data.Renderer,
+		Formats:  rendered,
+	})
+
 	return uint64(written), nil
 }
+
+// pageUpToDate returns true if the rendered page of job exists and
+// nothing it depends on changed since the last run.
+func pageUpToDate(job renderJob, gv *globalView) bool {
+	product := job.meta.Package.Product
+	rel := relServingPath(job.dest)
+
+	last := gv.lastState.page(product, rel)
+	if last == nil {
+		return false
+	}
+	for _, f := range pageFiles(job.dest) {
+		if _, err := os.Stat(f); err != nil {
+			return false
+		}
+	}
+	input, err := pageInput(job, last.Refs, gv)
+	if err != nil || input != last.Input {
+		return false
+	}
+
+	// all formats exist, the state of older versions does not
+	// know them
+	last.Formats = formatNames()
+	gv.state.setPage(product, rel, last)
+	return true
+}
+
+// keepLastPage keeps the page of the last run for job, which could not
+// be rendered. It is rendered again in the next run.
+func keepLastPage(job renderJob, gv *globalView) {
+	product := job.meta.Package.Product
+	rel := relServingPath(job.dest)
+
+	last := gv.lastState.page(product, rel)
+	if last == nil {
+		return
+	}
+	if _, err := os.Stat(job.dest); err != nil {
+		return
+	}
+	// the input of the page changed, so it will not be up to date
+	gv.state.setPage(product, rel, last)
+}
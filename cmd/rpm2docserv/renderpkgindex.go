@@ -60,7 +60,7 @@ func renderPkgIndex(dest string, manpageByName map[string]*manpage.Meta, gv *glo
 			IsOffline:      isOffline,
 			Breadcrumbs: breadcrumbs{
 				{fmt.Sprintf("/%s/index.html", first.Package.Product), first.Package.Product},
-				{"", first.Package.Binarypkg},
+				{"", first.Package.Dir()},
 			},
 			First:         first,
 			Meta:          first,
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Classes of errors, which do not stop the run immediately, but count
+// against the error budget of the product.
+const (
+	errorDownload  = "download"  // package cannot be downloaded
+	errorScan      = "scan"      // package cannot be read
+	errorSignature = "signature" // package skipped by the signature policy
+	errorExtract   = "extract"   // package cannot be extracted
+	errorRender    = "render"    // manpage cannot be written
+	errorIndex     = "index"     // index page cannot be written
+)
+
+// exitWarnings is the exit code of a run, which published the result
+// despite errors within the error budgets. 1 is used by log.Fatal and
+// 2 by the Go runtime for a panic.
+const exitWarnings = 3
+
+// runErrors collects the errors of a run per product.
+type runErrors struct {
+	mu sync.Mutex
+	// counts per product and class
+	counts map[string]map[string]int
+	// failed are the packages (or pages for index errors) with
+	// errors per product
+	failed map[string]map[string]bool
+}
+
+func newRunErrors() *runErrors {
+	return &runErrors{
+		counts: make(map[string]map[string]int),
+		failed: make(map[string]map[string]bool),
+	}
+}
+
+// add logs err and counts it for product. unit is the package (or
+// index page) affected by the error.
+func (e *runErrors) add(product string, class string, unit string, err error) {
+	log.Printf("ERROR: %s: %s: %v", product, class, err)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.counts[product] == nil {
+		e.counts[product] = make(map[string]int)
+		e.failed[product] = make(map[string]bool)
+	}
+	e.counts[product][class]++
+	e.failed[product][unit] = true
+}
+
+// failedUnit returns true if an error of unit of product was added.
+func (e *runErrors) failedUnit(product string, unit string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.failed[product][unit]
+}
+
+// total returns the number of all errors.
+func (e *runErrors) total() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, counts := range e.counts {
+		for _, c := range counts {
+			n += c
+		}
+	}
+	return n
+}
+
+type errorCount struct {
+	Product string
+	Class   string
+	Count   int
+}
+
+// list returns the number of errors per product and class, sorted.
+func (e *runErrors) list() []errorCount {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var result []errorCount
+	for product, counts := range e.counts {
+		for class, c := range counts {
+			result = append(result, errorCount{product, class, c})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Product != result[j].Product {
+			return result[i].Product < result[j].Product
+		}
+		return result[i].Class < result[j].Class
+	})
+	return result
+}
+
+// printErrors prints the number of errors per product and class as
+// part of the summary of the run.
+func printErrors(e *runErrors) {
+	fmt.Printf("errors:                   %d\n", e.total())
+	for _, c := range e.list() {
+		fmt.Printf("%-26s%d\n", fmt.Sprintf("  %s/%s:", c.Product, c.Class), c.Count)
+	}
+}
+
+// errorBudget is the number of packages of a product, which may have
+// errors without failing the run.
+type errorBudget struct {
+	limit float64
+	// percent means limit is a percentage of the packages
+	percent   bool
+	unlimited bool
+}
+
+// parseErrorBudget parses an error budget, either an absolute number
+// ("10") or a percentage of the packages of the product ("2.5%"). An
+// empty budget is unlimited.
+func parseErrorBudget(s string) (errorBudget, error) {
+	if s == "" {
+		return errorBudget{unlimited: true}, nil
+	}
+	b := errorBudget{}
+	num := s
+	if strings.HasSuffix(s, "%") {
+		b.percent = true
+		num = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	}
+	limit, err := strconv.ParseFloat(num, 64)
+	if err != nil || limit < 0 || (!b.percent && limit != float64(int(limit))) {
+		return b, fmt.Errorf("invalid error budget %q", s)
+	}
+	b.limit = limit
+	return b, nil
+}
+
+// exceeded returns true if failed of total packages are more than
+// the budget allows.
+func (b errorBudget) exceeded(failed int, total int) bool {
+	switch {
+	case b.unlimited:
+		return false
+	case b.percent:
+		return total > 0 && float64(failed)*100 > b.limit*float64(total)
+	default:
+		return float64(failed) > b.limit
+	}
+}
+
+// setupErrorBudgets sets the error budget of all products without one
+// to the default of the config and checks them.
+func setupErrorBudgets(config Config, products []Product) error {
+	if _, err := parseErrorBudget(config.ErrorBudget); err != nil {
+		return err
+	}
+	for i := range products {
+		if products[i].ErrorBudget == "" {
+			products[i].ErrorBudget = config.ErrorBudget
+		}
+		if _, err := parseErrorBudget(products[i].ErrorBudget); err != nil {
+			return fmt.Errorf("product %q: %v", products[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// checkBudgets returns an error if a product has more packages with
+// errors than its error budget allows.
+func (e *runErrors) checkBudgets(gv *globalView) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, product := range gv.productList {
+		failed := e.failed[product]
+		if len(failed) == 0 {
+			continue
+		}
+		// the packages which could not be read are not part of
+		// gv.pkgs
+		units := make(map[string]bool, len(failed))
+		for unit := range failed {
+			units[unit] = true
+		}
+		for _, pkg := range gv.pkgs {
+			if pkg.Product == product {
+				units[pkg.Filename] = true
+			}
+		}
+		if b := gv.errorBudgets[product]; b.exceeded(len(failed), len(units)) {
+			return fmt.Errorf("product %q: %d of %d packages have errors, more than the error budget of %s",
+				product, len(failed), len(units), b)
+		}
+	}
+	return nil
+}
+
+func (b errorBudget) String() string {
+	if b.unlimited {
+		return "unlimited"
+	}
+	s := strconv.FormatFloat(b.limit, 'f', -1, 64)
+	if b.percent {
+		s += "%"
+	}
+	return s
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"github.com/thkukuk/rpm2docserv/pkg/pkgsource"
+	"github.com/thkukuk/rpm2docserv/pkg/rpm"
+)
+
+// What to do with a package, which is not signed or whose signature
+// cannot be verified
+const (
+	signatureSkip = "skip"
+	signatureFail = "fail"
+)
+
+// signatureCheck is the keyring and policy of a product
+type signatureCheck struct {
+	keyring openpgp.EntityList
+	policy  string
+}
+
+// setupSignaturePolicy validates the signature policies and applies
+// the global one to all products without an own policy.
+func setupSignaturePolicy(config Config, products []Product) error {
+	switch config.SignaturePolicy {
+	case "", signatureSkip, signatureFail:
+	default:
+		return fmt.Errorf("invalid signature_policy %q", config.SignaturePolicy)
+	}
+	for i := range products {
+		switch products[i].SignaturePolicy {
+		case "":
+			products[i].SignaturePolicy = config.SignaturePolicy
+		case signatureSkip, signatureFail:
+		default:
+			return fmt.Errorf("product %q: invalid signature_policy %q",
+				products[i].Name, products[i].SignaturePolicy)
+		}
+		if products[i].SignaturePolicy == "" {
+			products[i].SignaturePolicy = signatureSkip
+		}
+		if products[i].Installed && len(products[i].GPGKeys) > 0 {
+			return fmt.Errorf("product %q: gpgkeys cannot be used for installed packages",
+				products[i].Name)
+		}
+	}
+	return nil
+}
+
+// readSignatureCheck reads the keyring of product. It returns nil if
+// no keys are configured and the signatures should not be verified.
+func readSignatureCheck(product Product) (*signatureCheck, error) {
+	if len(product.GPGKeys) == 0 {
+		return nil, nil
+	}
+	keyring, err := rpm.ReadKeyring(product.GPGKeys...)
+	if err != nil {
+		return nil, err
+	}
+	return &signatureCheck{
+		keyring: keyring,
+		policy:  product.SignaturePolicy,
+	}, nil
+}
+
+// checkSignature verifies the signature of the package fn of product,
+// if a keyring is configured for it, and sets rs.SignedBy and
+// rs.Digest. Packages which are no RPMs (including the ones of a
+// directory tree) count as unsigned. The package is verified in every
+// run: the build state only knows the size and modification time (or
+// the checksum in the repository metadata) of the file, which do not
+// protect against a modified package.
+// It returns false if the package must not be used and an error if
+// the run should fail because of the signature policy.
+func checkSignature(product string, fn string, rs *rpmState, gv *globalView) (bool, error) {
+	sc := gv.signatures[product]
+	rs.SignedBy = ""
+	rs.Digest = ""
+	if sc == nil {
+		return true, nil
+	}
+
+	var keyID uint64
+	var err error
+	if (pkgsource.RPM{}).IsPackage(fn) {
+		keyID, rs.Digest, err = verifyRPM(fn, sc.keyring)
+	} else {
+		err = fmt.Errorf("only RPMs can be verified, %w", rpm.ErrUnsigned)
+	}
+	switch {
+	case err == nil:
+		atomic.AddUint64(&gv.stats.SignaturesValid, 1)
+		rs.SignedBy = fmt.Sprintf("%016X", keyID)
+		return true, nil
+	case errors.Is(err, rpm.ErrUnsigned):
+		atomic.AddUint64(&gv.stats.SignaturesUnsigned, 1)
+	default:
+		atomic.AddUint64(&gv.stats.SignaturesBad, 1)
+	}
+
+	if sc.policy == signatureFail {
+		return false, fmt.Errorf("%s: %v", filepath.Base(fn), err)
+	}
+	gv.errors.add(product, errorSignature, fn, fmt.Errorf("ignoring %q: %v", filepath.Base(fn), err))
+	return false, nil
+}
+
+// verifyRPM verifies the signatures of the RPM fn, which can be part
+// of an ISO image. It returns the key ID and the digest of the file.
+func verifyRPM(fn string, keyring openpgp.KeyRing) (uint64, string, error) {
+	f, err := pkgsource.Open(fn)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	tee := io.TeeReader(f, h)
+	r, err := rpm.NewReader(tee)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %v", fn, err)
+	}
+	keyID, err := r.Verify(keyring)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return 0, "", err
+	}
+	return keyID, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractVerified extracts the files of the RPM fn, for which want
+// returns true, into destDir, if it is still the file with digest
+// whose signature was verified. The digest is computed over the data
+// which is extracted, so the file cannot be exchanged in between. On
+// error, the content of destDir must not be used.
+func extractVerified(fn string, destDir string, want func(name string) bool, digest string) error {
+	f, err := pkgsource.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	tee := io.TeeReader(f, h)
+	r, err := rpm.NewReader(tee)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	if err := r.Extract(destDir, want); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		return errors.New("package changed since its signature was verified")
+	}
+	return nil
+}
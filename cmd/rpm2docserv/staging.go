@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/thkukuk/rpm2docserv/pkg/write"
+)
+
+// A run does not modify the published products, but builds them in a
+// staging directory and switches over only if everything succeeded:
+//
+//	<servingdir>/.staging/<product>           the product being built
+//	<servingdir>/.generations/<product>/<n>   the published generations
+//	<servingdir>/<product>                    symlink to the live generation
+//
+// The staging directory starts as a hardlinked copy of the live
+// generation, so that unchanged pages are not rendered again. All
+// files are written to temporary files and renamed, so the files
+// shared with the live generation are never modified.
+const (
+	stagingDir     = ".staging"
+	generationsDir = ".generations"
+	// name of the auxserver index in the staging directory
+	stagingIndex = ".auxserver.idx"
+)
+
+// buildDir is the directory the current run writes to, the staging
+// directory.
+var buildDir string
+
+type staging struct {
+	servingDir string
+	dir        string
+	// generations are the new generations of all products
+	generations map[string]string
+	// number of pages of the live generations
+	livePages map[string]int
+}
+
+// newStaging creates the staging directory for products. If the live
+// generation of a product is not the one described by lastState (e.g.
+// after a rollback), the product is fully rebuild.
+func newStaging(servingDir string, products []Product, lastState *buildState) (*staging, error) {
+	s := &staging{
+		servingDir:  servingDir,
+		dir:         filepath.Join(servingDir, stagingDir),
+		generations: make(map[string]string, len(products)),
+		livePages:   make(map[string]int, len(products)),
+	}
+	// leftover of a failed run
+	if err := os.RemoveAll(s.dir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(s.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, product := range products {
+		live, err := s.liveGeneration(product.Name, lastState)
+		if err != nil {
+			return nil, fmt.Errorf("product %q: %v", product.Name, err)
+		}
+		if ps, ok := lastState.Products[product.Name]; ok && ps.Generation != live {
+			log.Printf("Generation %q of %q is live, but the build state describes %q, doing a full rebuild",
+				live, product.Name, ps.Generation)
+			delete(lastState.Products, product.Name)
+		}
+		if ps, ok := lastState.Products[product.Name]; ok {
+			s.livePages[product.Name] = len(ps.Pages)
+		}
+		if live != "" {
+			src := filepath.Join(servingDir, generationsDir, product.Name, live)
+			if err := linkTree(src, filepath.Join(s.dir, product.Name)); err != nil {
+				return nil, fmt.Errorf("copying generation %s of %q: %v", live, product.Name, err)
+			}
+		}
+		next, err := nextGeneration(servingDir, product.Name)
+		if err != nil {
+			return nil, err
+		}
+		s.generations[product.Name] = next
+	}
+	return s, nil
+}
+
+// liveGeneration returns the generation of product, which is served
+// at the moment, or "" if there is none. A product directory of an
+// older version of rpm2docserv is moved into the first generation.
+func (s *staging) liveGeneration(product string, lastState *buildState) (string, error) {
+	live := filepath.Join(s.servingDir, product)
+	fi, err := os.Lstat(live)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(live)
+		if err != nil {
+			return "", err
+		}
+		if filepath.Dir(target) != filepath.Join(generationsDir, product) {
+			return "", fmt.Errorf("%s points to %q, not to a generation", live, target)
+		}
+		return filepath.Base(target), nil
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s is no directory", live)
+	}
+
+	gen, err := nextGeneration(s.servingDir, product)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Moving %q into generation %s", live, gen)
+	if err := s.publishGeneration(live, product, gen); err != nil {
+		return "", err
+	}
+	// the build state describes the moved directory
+	if ps, ok := lastState.Products[product]; ok {
+		ps.Generation = gen
+	}
+	return gen, nil
+}
+
+// generations returns the generations of product, oldest first.
+func generations(servingDir string, product string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(servingDir, generationsDir, product))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var result []int
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			result = append(result, n)
+		}
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+func nextGeneration(servingDir string, product string) (string, error) {
+	gens, err := generations(servingDir, product)
+	if err != nil {
+		return "", err
+	}
+	if len(gens) == 0 {
+		return "1", nil
+	}
+	return strconv.Itoa(gens[len(gens)-1] + 1), nil
+}
+
+// linkTree recreates the directory tree src as dst, with hardlinks to
+// the files of src.
+func linkTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return os.Link(path, target)
+		}
+	})
+}
+
+// check verifies the staged products before they are published: every
+// rendered product needs its index and must not lose more than
+// maxShrink percent of its pages (if set), or all of them.
+func (s *staging) check(gv *globalView, maxShrink int) error {
+	for product := range s.generations {
+		cur := len(gv.state.product(product).Pages)
+		if gv.renderProduct[product] && cur > 0 {
+			if _, err := os.Stat(filepath.Join(s.dir, product, "index.html")); err != nil {
+				return fmt.Errorf("product %q: no index: %v", product, err)
+			}
+		}
+
+		last := s.livePages[product]
+		if last == 0 {
+			continue
+		}
+		if cur == 0 {
+			return fmt.Errorf("product %q: all %d pages would be removed", product, last)
+		}
+		if lost := (last - cur) * 100 / last; maxShrink > 0 && lost > maxShrink {
+			return fmt.Errorf("product %q: %d%% of the pages would be removed (%d of %d), at most %d%% are allowed",
+				product, lost, last-cur, last, maxShrink)
+		}
+	}
+	return nil
+}
+
+// publish makes the staged products live, followed by the files of
+// the serving directory (e.g. index.html and the build state) and the
+// auxserver index. Of the older generations, keep are kept.
+func (s *staging) publish(indexPath string, keep int) error {
+	products := make([]string, 0, len(s.generations))
+	for product := range s.generations {
+		products = append(products, product)
+	}
+	sort.Strings(products)
+
+	for _, product := range products {
+		src := filepath.Join(s.dir, product)
+		// products without any manpage have no directory
+		if err := os.MkdirAll(src, 0755); err != nil {
+			return err
+		}
+		if err := s.publishGeneration(src, product, s.generations[product]); err != nil {
+			return fmt.Errorf("publishing %q: %v", product, err)
+		}
+		if *verbose {
+			log.Printf("Published generation %s of %q", s.generations[product], product)
+		}
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || e.Name() == stagingIndex {
+			continue
+		}
+		if err := os.Rename(filepath.Join(s.dir, e.Name()), filepath.Join(s.servingDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	if err := moveFile(filepath.Join(s.dir, stagingIndex), indexPath); err != nil {
+		return fmt.Errorf("publishing index: %v", err)
+	}
+
+	if err := os.RemoveAll(s.dir); err != nil {
+		log.Printf("Cannot remove %q: %v", s.dir, err)
+	}
+	for _, product := range products {
+		s.prune(product, keep)
+	}
+	return nil
+}
+
+// publishGeneration moves dir to generation gen of product and points
+// the live symlink to it.
+func (s *staging) publishGeneration(dir string, product string, gen string) error {
+	genDir := filepath.Join(s.servingDir, generationsDir, product)
+	if err := os.MkdirAll(genDir, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, filepath.Join(genDir, gen)); err != nil {
+		return err
+	}
+
+	// renaming a symlink over the old one switches atomically
+	tmp := filepath.Join(s.servingDir, "."+product+".link")
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(generationsDir, product, gen), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.servingDir, product))
+}
+
+// prune deletes all generations of product except for the live one
+// and the keep newest older ones.
+func (s *staging) prune(product string, keep int) {
+	gens, err := generations(s.servingDir, product)
+	if err != nil {
+		log.Printf("Cannot list the generations of %q: %v", product, err)
+		return
+	}
+	live := s.generations[product]
+	older := gens[:0]
+	for _, gen := range gens {
+		if strconv.Itoa(gen) != live {
+			older = append(older, gen)
+		}
+	}
+	for len(older) > keep {
+		dir := filepath.Join(s.servingDir, generationsDir, product, strconv.Itoa(older[0]))
+		if *verbose {
+			log.Printf("Removing old generation %q", dir)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Cannot remove %q: %v", dir, err)
+		}
+		older = older[1:]
+	}
+}
+
+// moveFile renames src to dst or, if they are on different file
+// systems, copies it.
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return write.Atomically(dst, false, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+// signalAuxserver sends SIGHUP to all docserv-auxserver processes, so
+// that they load the new index, like the docserv-auxserver-reload
+// service does.
+func signalAuxserver() {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		log.Printf("Cannot reload docserv-auxserver: %v", err)
+		return
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+		if filepath.Base(argv0) != "docserv-auxserver" {
+			continue
+		}
+		if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+			log.Printf("Cannot send SIGHUP to docserv-auxserver (pid %d): %v", pid, err)
+			continue
+		}
+		log.Printf("Sent SIGHUP to docserv-auxserver (pid %d)", pid)
+	}
+}
@@ -2,14 +2,32 @@ package main
 
 import (
 	"io"
+	"slices"
 	"sort"
 	"sync/atomic"
 
+	"github.com/thkukuk/rpm2docserv/pkg/manpage"
 	pb "github.com/thkukuk/rpm2docserv/pkg/proto"
 	"github.com/thkukuk/rpm2docserv/pkg/write"
 	"google.golang.org/protobuf/proto"
 )
 
+// missingSuffixes returns the suffixes of the formats, which were not
+// rendered for the manpage m (all, if the page was not rendered).
+func missingSuffixes(m *manpage.Meta, gv *globalView) []string {
+	var rendered []string
+	if page := gv.state.page(m.Package.Product, m.ServingPath()+".html.gz"); page != nil {
+		rendered = page.Formats
+	}
+	var missing []string
+	for _, f := range formats {
+		if !slices.Contains(rendered, f.Name) {
+			missing = append(missing, f.Suffix)
+		}
+	}
+	return missing
+}
+
 // writeIndex serializes an index for the redirect package (used in
 // docserv-auxserver) to dest.
 func writeIndex(dest string, gv *globalView) error {
@@ -22,11 +40,13 @@ func writeIndex(dest string, gv *globalView) error {
 	for _, x := range gv.xref {
 		for _, m := range x {
 			idx.Entry = append(idx.Entry, &pb.IndexEntry{
-				Name:      m.Name,
-				Suite:     m.Package.Product,
-				Binarypkg: m.Package.Binarypkg,
-				Section:   m.Section,
-				Language:  m.Language,
+				Name:            m.Name,
+				Suite:           m.Package.Product,
+				Binarypkg:       m.Package.Binarypkg,
+				Section:         m.Section,
+				Language:        m.Language,
+				Version:         m.Package.Version.String(),
+				MissingSuffixes: missingSuffixes(m, gv),
 			})
 			langs[m.Language] = true
 			sections[m.Section] = true
@@ -48,6 +68,12 @@ func writeIndex(dest string, gv *globalView) error {
 
 	idx.Products = gv.productList
 
+	idx.RawSuffix = manpage.RawSuffix
+
+	for _, f := range formats {
+		idx.FormatSuffixes = append(idx.FormatSuffixes, f.Suffix)
+	}
+
 	idxb, err := proto.Marshal(idx)
 	if err != nil {
 		return err
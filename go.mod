@@ -12,4 +12,15 @@ require (
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require google.golang.org/protobuf v1.36.10
+require (
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.12
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	github.com/cloudflare/circl v1.6.0 // indirect
+	golang.org/x/crypto v0.43.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
+)
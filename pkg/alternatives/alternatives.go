@@ -0,0 +1,147 @@
+// Package alternatives finds the update-alternatives(8) calls in
+// package scripts, so that manual pages which are only installed as
+// alternative (e.g. by python or java packages) can be resolved.
+package alternatives
+
+import (
+	"path"
+	"strconv"
+)
+
+// Slave is a link which is switched together with the master link of
+// an alternative (--slave).
+type Slave struct {
+	Link string
+	Name string
+	Path string
+}
+
+// Alternative is one "update-alternatives --install" call.
+type Alternative struct {
+	// Link is the generic name, e.g. /usr/bin/python3
+	Link string
+	// Name is the name of the alternative, e.g. python3
+	Name string
+	// Path is the file the link points to if this alternative is
+	// selected, e.g. /usr/bin/python3.11
+	Path     string
+	Priority int
+	Slaves   []Slave
+}
+
+// Lookup returns the path which the link (the master link or one of
+// the slaves) points to if the alternative is selected. The links are
+// compared with match.
+func (a *Alternative) Lookup(match func(link string) bool) (string, bool) {
+	if match(a.Link) {
+		return a.Path, true
+	}
+	for _, s := range a.Slaves {
+		if match(s.Link) {
+			return s.Path, true
+		}
+	}
+	return "", false
+}
+
+// Resolve returns the path of the link matched by match of the
+// alternative with the highest priority, as update-alternatives would
+// select it in automatic mode, and all alternatives providing it.
+func Resolve(alts []Alternative, match func(link string) bool) (string, []Alternative) {
+	var best string
+	var bestPrio int
+	var found []Alternative
+	for _, a := range alts {
+		p, ok := a.Lookup(match)
+		if !ok {
+			continue
+		}
+		if len(found) == 0 || a.Priority > bestPrio {
+			best, bestPrio = p, a.Priority
+		}
+		found = append(found, a)
+	}
+	return best, found
+}
+
+// options of update-alternatives and the number of their arguments,
+// which are not relevant here.
+var skipArgs = map[string]int{
+	"--altdir":     1,
+	"--admindir":   1,
+	"--instdir":    1,
+	"--log":        1,
+	"--remove":     2,
+	"--set":        2,
+	"--remove-all": 1,
+	"--auto":       1,
+	"--display":    1,
+	"--query":      1,
+	"--config":     1,
+	"--list":       1,
+}
+
+// Parse returns all alternatives installed by script, a shell script
+// like the postinstall scriptlet of a RPM.
+func Parse(script string) []Alternative {
+	var alts []Alternative
+	for _, cmd := range Commands(script) {
+		start := -1
+		for i, w := range cmd {
+			if path.Base(w) == "update-alternatives" {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			continue
+		}
+		alts = append(alts, parseArgs(cmd[start:])...)
+	}
+	return alts
+}
+
+func parseArgs(args []string) []Alternative {
+	var alts []Alternative
+	// index of the alternative to which --slave belongs
+	cur := -1
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--install":
+			if i+4 >= len(args) {
+				return alts
+			}
+			// A priority which is no number (e.g. an unknown
+			// variable) is the lowest one.
+			prio, _ := strconv.Atoi(args[i+4])
+			alts = append(alts, Alternative{
+				Link:     args[i+1],
+				Name:     args[i+2],
+				Path:     args[i+3],
+				Priority: prio,
+			})
+			cur = len(alts) - 1
+			i += 4
+		case "--slave":
+			if i+3 >= len(args) {
+				return alts
+			}
+			if cur >= 0 {
+				alts[cur].Slaves = append(alts[cur].Slaves, Slave{
+					Link: args[i+1],
+					Name: args[i+2],
+					Path: args[i+3],
+				})
+			}
+			i += 3
+		default:
+			if n, ok := skipArgs[args[i]]; ok {
+				if args[i] == "--remove" || args[i] == "--set" {
+					cur = -1
+				}
+				i += n
+			}
+		}
+	}
+	return alts
+}
@@ -0,0 +1,223 @@
+package alternatives
+
+import (
+	"strings"
+)
+
+// tokenizer splits a shell script into simple commands and words. It
+// knows quoting, line continuations and comments and expands
+// variables set by simple assignments before. Everything else (command
+// substitution, arithmetic, control structures) is kept as words.
+type tokenizer struct {
+	s    []rune
+	pos  int
+	vars map[string]string
+
+	word   strings.Builder
+	inWord bool
+	cmd    []string
+	cmds   [][]string
+}
+
+// Commands splits script into simple commands, each a list of words
+// with quotes removed and known variables expanded. Variable
+// assignments are evaluated and not returned.
+func Commands(script string) [][]string {
+	t := &tokenizer{
+		s:    []rune(script),
+		vars: make(map[string]string),
+	}
+	t.run()
+	return t.cmds
+}
+
+func (t *tokenizer) endWord() {
+	if t.inWord {
+		t.cmd = append(t.cmd, t.word.String())
+	}
+	t.word.Reset()
+	t.inWord = false
+}
+
+func (t *tokenizer) endCommand() {
+	t.endWord()
+	if len(t.cmd) == 0 {
+		return
+	}
+	cmd := t.cmd
+	t.cmd = nil
+
+	// Leading assignments, an "export FOO=bar" or "local FOO=bar"
+	// sets the variables, too.
+	words := cmd
+	if words[0] == "export" || words[0] == "local" || words[0] == "readonly" {
+		words = words[1:]
+	}
+	for len(words) > 0 {
+		name, value, ok := assignment(words[0])
+		if !ok {
+			break
+		}
+		t.vars[name] = value
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return
+	}
+	t.cmds = append(t.cmds, cmd)
+}
+
+// assignment splits a word of the form NAME=value.
+func assignment(word string) (string, string, bool) {
+	name, value, ok := strings.Cut(word, "=")
+	if !ok || !validName(name) {
+		return "", "", false
+	}
+	return name, value, true
+}
+
+func isNameRune(r rune, first bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+	return !first && r >= '0' && r <= '9'
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isNameRune(r, i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tokenizer) peek() (rune, bool) {
+	if t.pos >= len(t.s) {
+		return 0, false
+	}
+	return t.s[t.pos], true
+}
+
+func (t *tokenizer) run() {
+	for t.pos < len(t.s) {
+		r := t.s[t.pos]
+		t.pos++
+		switch {
+		case r == ' ' || r == '\t':
+			t.endWord()
+		case r == '\n' || r == ';' || r == '&' || r == '|':
+			t.endCommand()
+		case r == '#' && !t.inWord:
+			for t.pos < len(t.s) && t.s[t.pos] != '\n' {
+				t.pos++
+			}
+		case r == '\\':
+			t.inWord = true
+			if n, ok := t.peek(); ok {
+				t.pos++
+				if n != '\n' {
+					t.word.WriteRune(n)
+				} else if t.word.Len() == 0 {
+					// line continuation between words
+					t.inWord = false
+				}
+			}
+		case r == '\'':
+			t.inWord = true
+			for t.pos < len(t.s) && t.s[t.pos] != '\'' {
+				t.word.WriteRune(t.s[t.pos])
+				t.pos++
+			}
+			t.pos++
+		case r == '"':
+			t.inWord = true
+			t.doubleQuoted()
+		case r == '$':
+			t.inWord = true
+			t.expand()
+		default:
+			t.inWord = true
+			t.word.WriteRune(r)
+		}
+	}
+	t.endCommand()
+}
+
+func (t *tokenizer) doubleQuoted() {
+	for t.pos < len(t.s) {
+		r := t.s[t.pos]
+		t.pos++
+		switch r {
+		case '"':
+			return
+		case '\\':
+			n, ok := t.peek()
+			if !ok {
+				continue
+			}
+			switch n {
+			case '$', '`', '"', '\\':
+				t.word.WriteRune(n)
+				t.pos++
+			case '\n':
+				t.pos++
+			default:
+				t.word.WriteRune(r)
+			}
+		case '$':
+			t.expand()
+		default:
+			t.word.WriteRune(r)
+		}
+	}
+}
+
+// expand writes the value of the variable starting at pos (after the
+// "$") to the current word. Unknown variables and everything which is
+// no simple variable reference are written as they are.
+func (t *tokenizer) expand() {
+	if n, ok := t.peek(); ok && n == '{' {
+		end := t.pos + 1
+		for end < len(t.s) && t.s[end] != '}' {
+			end++
+		}
+		if end >= len(t.s) {
+			t.word.WriteRune('$')
+			return
+		}
+		expr := string(t.s[t.pos+1 : end])
+		t.pos = end + 1
+
+		// ${NAME:-default} and ${NAME-default}
+		name, def, hasDef := strings.Cut(expr, "-")
+		colon := strings.HasSuffix(name, ":")
+		name = strings.TrimSuffix(name, ":")
+		v, set := t.vars[name]
+		switch {
+		case !validName(name):
+			t.word.WriteString("${" + expr + "}")
+		case set && (v != "" || !colon):
+			t.word.WriteString(v)
+		case hasDef:
+			t.word.WriteString(def)
+		default:
+			t.word.WriteString("${" + expr + "}")
+		}
+		return
+	}
+
+	start := t.pos
+	for t.pos < len(t.s) && isNameRune(t.s[t.pos], t.pos == start) {
+		t.pos++
+	}
+	name := string(t.s[start:t.pos])
+	if v, ok := t.vars[name]; ok {
+		t.word.WriteString(v)
+		return
+	}
+	t.word.WriteString("$" + name)
+}
@@ -2,6 +2,7 @@ package convert
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -412,8 +413,47 @@ func ToHTML(r io.Reader, resolve func(ref string) string) (doc string, toc []str
 	if err != nil {
 		return "", nil, fmt.Errorf("running mandoc failed: %v", err)
 	}
+	return finishHTML(stdout, resolve)
+}
+
+// Renderers of RenderHTML
+const (
+	Mandoc = "mandoc"
+	Groff  = "groff"
+)
+
+// RenderHTML is like ToHTML, but falls back to groff if mandoc fails,
+// e.g. for pages using pic or groff-only macros. With preferGroff
+// groff is tried first. It returns the renderer which produced doc.
+func RenderHTML(r io.Reader, resolve func(ref string) string, preferGroff bool) (doc string, toc []string, renderer string, err error) {
+	// the source is needed once per renderer
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	renderers := []string{Mandoc, Groff}
+	if preferGroff {
+		renderers = []string{Groff, Mandoc}
+	}
+	var errs []string
+	for _, renderer := range renderers {
+		if renderer == Mandoc {
+			doc, toc, err = ToHTML(bytes.NewReader(src), resolve)
+		} else {
+			doc, toc, err = groffToHTML(bytes.NewReader(src), resolve)
+		}
+		if err == nil {
+			return doc, toc, renderer, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", nil, "", errors.New(strings.Join(errs, "; "))
+}
 
-	parsed, err := html.Parse(strings.NewReader(stdout))
+// finishHTML post-processes the HTML fragment of a renderer.
+func finishHTML(fragment string, resolve func(ref string) string) (doc string, toc []string, err error) {
+	parsed, err := html.Parse(strings.NewReader(fragment))
 	if err != nil {
 		return "", nil, err
 	}
@@ -0,0 +1,59 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ToText renders the manpage r as plain UTF-8 text for terminals,
+// without the overstrike sequences for bold and underlined text.
+func ToText(r io.Reader) (string, error) {
+	out, err := mandocFormat(r, "utf8")
+	if err != nil {
+		return "", err
+	}
+	return stripOverstrike(out), nil
+}
+
+// ToPDF renders the manpage r as PDF document.
+func ToPDF(r io.Reader) (string, error) {
+	return mandocFormat(r, "pdf")
+}
+
+// ToMarkdown renders the manpage r as Markdown.
+func ToMarkdown(r io.Reader) (string, error) {
+	return mandocFormat(r, "markdown")
+}
+
+// mandocFormat renders the manpage r with the output format format of
+// mandoc. Like for HTML, any message of mandoc is an error.
+func mandocFormat(r io.Reader, format string) (string, error) {
+	stdout, stderr, err := mandocFork(r, "-T"+format)
+	if stderr != "" {
+		return "", fmt.Errorf("mandoc failed: %v", stderr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("running mandoc failed: %v", err)
+	}
+	return stdout, nil
+}
+
+// stripOverstrike removes the overstrike sequences of nroff output:
+// "c\bc" (bold) and "_\bc" (underline) become "c".
+func stripOverstrike(s string) string {
+	if !strings.ContainsRune(s, '\b') {
+		return s
+	}
+	result := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r == '\b' {
+			if len(result) > 0 {
+				result = result[:len(result)-1]
+			}
+			continue
+		}
+		result = append(result, r)
+	}
+	return string(result)
+}
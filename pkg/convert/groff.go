@@ -0,0 +1,150 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// groffArgs renders with the man macros and the preprocessors for
+// tables, equations and pictures, without the index of the sections
+// (-l) and the horizontal rules (-r) grohtml adds.
+var groffArgs = []string{"-Thtml", "-mandoc", "-t", "-e", "-p", "-P-l", "-P-r"}
+
+// groffToHTML renders the manpage r with groff and post-processes the
+// result like the output of mandoc.
+func groffToHTML(r io.Reader, resolve func(ref string) string) (doc string, toc []string, err error) {
+	fragment, err := groff(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return finishHTML(fragment, resolve)
+}
+
+// groff runs groff and returns the body of the document as fragment
+// in the structure of mandoc: <div class="mandoc">, the sections as
+// <h1> and the subsections as <h2>. Images (e.g. of pic) are embedded.
+// Unlike mandoc, groff warns about many pages it renders fine, so only
+// its exit code counts.
+func groff(r io.Reader) (string, error) {
+	dir, err := os.MkdirTemp("", "groff-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	args := append([]string(nil), groffArgs...)
+	// grap is not part of groff
+	if _, err := exec.LookPath("grap"); err == nil {
+		args = append(args, "-G")
+	}
+	// the images are written to dir/img
+	args = append(args, "-P-Dimg", "-P-Iimage")
+
+	var stdoutb, stderrb bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(60)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "groff", args...)
+	cmd.Dir = dir
+	cmd.Stdin = r
+	cmd.Stdout = &stdoutb
+	cmd.Stderr = &stderrb
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running groff failed: %v, stderr: %s", err, stderrb.String())
+	}
+
+	parsed, err := html.Parse(&stdoutb)
+	if err != nil {
+		return "", err
+	}
+	body := findElement(parsed, "body")
+	if body == nil {
+		return "", fmt.Errorf("groff failed: no HTML document")
+	}
+
+	div := &html.Node{
+		Type: html.ElementNode,
+		Data: "div",
+		Attr: []html.Attribute{{Key: "class", Val: "mandoc"}},
+	}
+	for body.FirstChild != nil {
+		c := body.FirstChild
+		body.RemoveChild(c)
+		div.AppendChild(c)
+	}
+	if err := recurse(div, func(n *html.Node) error { return groffNode(n, dir) }); err != nil {
+		return "", err
+	}
+
+	var rendered bytes.Buffer
+	if err := html.Render(&rendered, div); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+func findElement(n *html.Node, name string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == name {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// groffNode converts n of the output of grohtml.
+func groffNode(n *html.Node, dir string) error {
+	if n.Type != html.ElementNode {
+		return nil
+	}
+	switch n.Data {
+	case "h1":
+		// the title of the page (.TH), it is shown by the template
+		for _, a := range n.Attr {
+			if a.Key == "align" && a.Val == "center" {
+				n.Parent.RemoveChild(n)
+				break
+			}
+		}
+	case "h2", "h3", "h4", "h5", "h6":
+		// .SH is <h2>, but <h1> for mandoc
+		n.Data = fmt.Sprintf("h%d", n.Data[1]-'0'-1)
+		// the text without the anchor and the line breaks
+		// around it, which would end up in the id
+		text := strings.TrimSpace(plaintext(n))
+		for n.FirstChild != nil {
+			n.RemoveChild(n.FirstChild)
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	case "img":
+		for i, a := range n.Attr {
+			if a.Key != "src" || strings.Contains(a.Val, ":") {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, filepath.Clean("/"+a.Val)))
+			if err != nil {
+				return fmt.Errorf("groff image: %v", err)
+			}
+			typ := mime.TypeByExtension(filepath.Ext(a.Val))
+			if typ == "" {
+				typ = "image/png"
+			}
+			n.Attr[i].Val = "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	return nil
+}
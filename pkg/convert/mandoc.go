@@ -11,7 +11,7 @@ import (
 )
 
 func mandoc(r io.Reader) (stdout string, stderr string, err error) {
-	stdout, stderr, err = mandocFork(r)
+	stdout, stderr, err = mandocFork(r, "-Ofragment", "-Thtml")
 
 	// TODO(later): once a new-enough version of mandoc is in Debian,
 	// get rid of this compatibility code by changing our CSS to not
@@ -26,13 +26,13 @@ func mandoc(r io.Reader) (stdout string, stderr string, err error) {
 
 // Kill mandoc after some time, it should never take more than a minute
 // if it does, something is broken.
-func mandocFork(r io.Reader) (stdout string, stderr string, err error) {
+func mandocFork(r io.Reader, args ...string) (stdout string, stderr string, err error) {
 	var stdoutb, stderrb bytes.Buffer
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(60)*time.Second)
         defer cancel()
 
-	cmd := exec.CommandContext(ctx, "mandoc", "-Ofragment", "-Thtml")
+	cmd := exec.CommandContext(ctx, "mandoc", args...)
 	cmd.Stdin = r
 	cmd.Stdout = &stdoutb
 	cmd.Stderr = &stderrb
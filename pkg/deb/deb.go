@@ -0,0 +1,335 @@
+// Package deb reads Debian binary packages (.deb) natively: the ar
+// archive with control.tar and data.tar, which can be compressed
+// with gzip, xz, zstd, bzip2 or lzma or not at all.
+package deb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
+	"github.com/thkukuk/rpm2docserv/pkg/unpack"
+)
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+)
+
+// File is an entry of data.tar.
+type File struct {
+	// Name is the absolute path of the file, e.g. "/usr/bin/bash"
+	Name string
+	Mode fs.FileMode
+	Size int64
+	// Linkto is the target of a symlink or, for a hardlink, the
+	// name of the file it is linked to.
+	Linkto   string
+	Hardlink bool
+}
+
+// Script is a maintainer script, e.g. postinst.
+type Script struct {
+	Name        string
+	Interpreter string
+	Body        string
+}
+
+// Package contains the control data and the file list of a .deb.
+type Package struct {
+	Name          string
+	Version       string
+	Arch          string
+	Source        string
+	SourceVersion string
+
+	// Control contains all fields of the control file
+	Control map[string]string
+
+	Files   []File
+	Scripts []Script
+}
+
+// arReader iterates over the members of an ar archive.
+type arReader struct {
+	r         *bufio.Reader
+	remaining int64
+	pad       int64
+}
+
+func newArReader(r io.Reader) (*arReader, error) {
+	ar := &arReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(ar.r, magic); err != nil {
+		return nil, fmt.Errorf("reading ar magic: %v", err)
+	}
+	if string(magic) != arMagic {
+		return nil, errors.New("no Debian package (bad ar magic)")
+	}
+	return ar, nil
+}
+
+// next returns the name of the next member.
+func (ar *arReader) next() (string, error) {
+	if _, err := io.CopyN(io.Discard, ar.r, ar.remaining+ar.pad); err != nil {
+		return "", err
+	}
+
+	hdr := make([]byte, arHeaderSize)
+	if _, err := io.ReadFull(ar.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", fmt.Errorf("truncated ar header")
+		}
+		return "", err
+	}
+	if string(hdr[58:60]) != "`\n" {
+		return "", errors.New("bad ar member header")
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+	if err != nil || size < 0 {
+		return "", fmt.Errorf("bad ar member size %q", hdr[48:58])
+	}
+	ar.remaining = size
+	ar.pad = size % 2
+
+	// GNU ar terminates names with a slash
+	name := strings.TrimSpace(string(hdr[0:16]))
+	return strings.TrimSuffix(name, "/"), nil
+}
+
+func (ar *arReader) Read(b []byte) (int, error) {
+	if ar.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > ar.remaining {
+		b = b[:ar.remaining]
+	}
+	n, err := ar.r.Read(b)
+	ar.remaining -= int64(n)
+	if err == io.EOF && ar.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// member searches the tar archive prefix (e.g. "control.tar") in the
+// .deb and calls fn with a reader for it.
+func member(fn string, prefix string, cb func(tr *tar.Reader) error) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ar, err := newArReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	for {
+		name, err := ar.next()
+		if err == io.EOF {
+			return fmt.Errorf("%s: no %s found", fn, prefix)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+		if name != prefix && !strings.HasPrefix(name, prefix+".") {
+			continue
+		}
+
+		zr, err := decompress.NewReader(ar, decompress.FromFilename(name))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", fn, name, err)
+		}
+		defer zr.Close()
+		if err := cb(tar.NewReader(zr)); err != nil {
+			return fmt.Errorf("%s: %s: %v", fn, name, err)
+		}
+		return nil
+	}
+}
+
+// entryName converts a name in data.tar ("./usr/bin/bash") into an
+// absolute path.
+func entryName(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "."))
+}
+
+func parseControl(b []byte) map[string]string {
+	control := make(map[string]string)
+	var key string
+	for _, line := range strings.Split(string(b), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// continuation line
+			if key != "" {
+				control[key] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = k
+		control[key] = strings.TrimSpace(v)
+	}
+	return control
+}
+
+var maintainerScripts = map[string]bool{
+	"preinst":  true,
+	"postinst": true,
+	"prerm":    true,
+	"postrm":   true,
+}
+
+// ReadPackage reads the control data and the file list of the .deb fn.
+func ReadPackage(fn string) (*Package, error) {
+	pkg := new(Package)
+
+	err := member(fn, "control.tar", func(tr *tar.Reader) error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			name := strings.TrimPrefix(entryName(hdr.Name), "/")
+			if name != "control" && !maintainerScripts[name] {
+				continue
+			}
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if name == "control" {
+				pkg.Control = parseControl(b)
+				continue
+			}
+
+			script := Script{Name: name, Interpreter: "/bin/sh", Body: string(b)}
+			if bytes.HasPrefix(b, []byte("#!")) {
+				first, body, _ := strings.Cut(string(b), "\n")
+				script.Interpreter = strings.TrimSpace(strings.TrimPrefix(first, "#!"))
+				script.Body = body
+			}
+			pkg.Scripts = append(pkg.Scripts, script)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Control == nil {
+		return nil, fmt.Errorf("%s: no control file", fn)
+	}
+
+	pkg.Name = pkg.Control["Package"]
+	pkg.Version = pkg.Control["Version"]
+	pkg.Arch = pkg.Control["Architecture"]
+	// The Source field is missing if source and binary package
+	// have the same name and contains the version if it differs
+	// from the version of the binary package.
+	pkg.Source, pkg.SourceVersion = pkg.Name, pkg.Version
+	if src := pkg.Control["Source"]; src != "" {
+		name, version, ok := strings.Cut(src, " ")
+		pkg.Source = name
+		if ok {
+			pkg.SourceVersion = strings.Trim(strings.TrimSpace(version), "()")
+		}
+	}
+
+	err = member(fn, "data.tar", func(tr *tar.Reader) error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			f := File{
+				Name: entryName(hdr.Name),
+				Mode: hdr.FileInfo().Mode(),
+				Size: hdr.Size,
+			}
+			switch hdr.Typeflag {
+			case tar.TypeSymlink:
+				f.Linkto = hdr.Linkname
+			case tar.TypeLink:
+				f.Linkto = entryName(hdr.Linkname)
+				f.Hardlink = true
+				f.Mode = hdr.FileInfo().Mode().Perm()
+			}
+			pkg.Files = append(pkg.Files, f)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pkg, nil
+}
+
+// Extract writes all files of data.tar for which want returns true
+// below destDir, with the same guarantees as rpm.Extract: nothing is
+// written outside of destDir and symlinks are converted to relative
+// ones pointing inside destDir.
+func Extract(fn string, destDir string, want func(name string) bool) error {
+	return member(fn, "data.tar", func(tr *tar.Reader) error {
+		// already written files, for hardlinks
+		written := make(map[string]string)
+
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			name := entryName(hdr.Name)
+			if !want(name) {
+				continue
+			}
+			dst, err := unpack.SecurePath(destDir, name)
+			if err != nil {
+				return err
+			}
+
+			switch hdr.Typeflag {
+			case tar.TypeReg:
+				err = unpack.WriteFile(dst, tr, hdr.FileInfo().Mode(), hdr.ModTime)
+				written[name] = dst
+			case tar.TypeLink:
+				src, ok := written[entryName(hdr.Linkname)]
+				if !ok {
+					return fmt.Errorf("extracting %s: target %s of hardlink not extracted", name, hdr.Linkname)
+				}
+				err = unpack.Link(src, dst)
+				written[name] = dst
+			case tar.TypeSymlink:
+				err = unpack.WriteSymlink(dst, name, hdr.Linkname)
+			case tar.TypeDir:
+				err = os.MkdirAll(dst, 0755)
+			}
+			// Device files, fifos and sockets are never of interest
+			if err != nil {
+				return fmt.Errorf("extracting %s: %v", name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Names of the supported compression methods, as used by rpm for
+// the payload compressor.
+const (
+	None  = ""
+	Gzip  = "gzip"
+	Bzip2 = "bzip2"
+	Xz    = "xz"
+	Lzma  = "lzma"
+	Zstd  = "zstd"
+)
+
+var suffixes = map[string]string{
+	".gz":   Gzip,
+	".bz2":  Bzip2,
+	".xz":   Xz,
+	".lzma": Lzma,
+	".zst":  Zstd,
+}
+
+var magics = []struct {
+	magic  []byte
+	method string
+}{
+	{[]byte{0x1f, 0x8b}, Gzip},
+	{[]byte("BZh"), Bzip2},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, Xz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, Zstd},
+	{[]byte{0x5d, 0x00, 0x00}, Lzma},
+}
+
+// FromMagic returns the compression method of data starting with b,
+// or None if b does not start with any known magic bytes.
+func FromMagic(b []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(b, m.magic) {
+			return m.method
+		}
+	}
+	return None
+}
+
+// Suffix returns the usual file name suffix of method, e.g. ".gz"
+// for Gzip.
+func Suffix(method string) string {
+	for suffix, m := range suffixes {
+		if m == method {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// TrimSuffix removes a known compression suffix from name.
+func TrimSuffix(name string) string {
+	return strings.TrimSuffix(name, Suffix(FromFilename(name)))
+}
+
+// FromFilename returns the compression method of a file based on
+// its suffix, e.g. Gzip for "primary.xml.gz", or None if the suffix
+// is unknown.
+func FromFilename(name string) string {
+	for suffix, method := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return method
+		}
+	}
+	return None
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+func noop() error { return nil }
+
+// NewReader returns a reader which decompresses r using method.
+// Closing the reader does not close r.
+func NewReader(r io.Reader, method string) (io.ReadCloser, error) {
+	switch method {
+	case None:
+		return readCloser{r, noop}, nil
+	case Gzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	case Bzip2:
+		return readCloser{bzip2.NewReader(r), noop}, nil
+	case Xz:
+		zr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{zr, noop}, nil
+	case Lzma:
+		zr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{zr, noop}, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{zr, func() error { zr.Close(); return nil }}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression method %q", method)
+}
+
+// Detect returns a reader which decompresses r, using the first
+// bytes of r to find out the compression method, which is returned,
+// too. Data which is not compressed is returned as is, independent
+// of any file name suffix.
+func Detect(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, None, err
+	}
+	method := FromMagic(b)
+	zr, err := NewReader(br, method)
+	if err != nil {
+		return nil, method, err
+	}
+	return zr, method, nil
+}
+
+type fileReader struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (r fileReader) Close() error {
+	r.ReadCloser.Close()
+	return r.f.Close()
+}
+
+// Open opens the file fn and returns a reader for the decompressed
+// content, see Detect.
+func Open(fn string) (io.ReadCloser, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	zr, _, err := Detect(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return fileReader{zr, f}, nil
+}
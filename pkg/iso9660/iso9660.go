@@ -0,0 +1,402 @@
+// Package iso9660 reads the files of ISO 9660 images (e.g. installation
+// media) without mounting them. The long names of the Rock Ridge
+// extensions are preferred, followed by Joliet. Without any of them
+// the names are converted to lower case, like Linux does by default.
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize = 2048
+	// the volume descriptors start after the system area
+	firstDescriptor = 16
+
+	descPrimary       = 1
+	descSupplementary = 2
+	descTerminator    = 255
+
+	// flags of a directory record
+	flagDirectory   = 0x02
+	flagMultiExtent = 0x80
+
+	// limits against broken or malicious images
+	maxDepth       = 64
+	maxDescriptors = 64
+	maxFiles       = 1 << 20
+)
+
+// File is a regular file of an image.
+type File struct {
+	// Name is the path of the file below the root of the image,
+	// without leading "/", e.g. "suse/x86_64/bash-5.2-1.1.x86_64.rpm"
+	Name    string
+	Size    int64
+	ModTime time.Time
+
+	extents []extent
+}
+
+type extent struct {
+	block uint32
+	size  int64
+}
+
+// Image is an opened ISO 9660 image.
+type Image struct {
+	f         *os.File
+	blockSize int64
+	files     []*File
+	// Rock Ridge: offset of the entries in the system use area
+	susp    bool
+	suspLen int
+	joliet  bool
+	// dirs are the first blocks of the directories already read,
+	// against loops
+	dirs map[uint32]bool
+}
+
+type volume struct {
+	root      []byte
+	blockSize int64
+}
+
+// Open opens the image fn and reads its directory tree.
+func Open(fn string) (*Image, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{f: f}
+	if err := img.read(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return img, nil
+}
+
+// Close closes the file of the image, the files cannot be read
+// anymore.
+func (img *Image) Close() error {
+	return img.f.Close()
+}
+
+// Files returns all regular files of the image, in the order of the
+// directory tree.
+func (img *Image) Files() []*File {
+	return img.files
+}
+
+// Open returns a reader for the content of f.
+func (img *Image) Open(f *File) io.Reader {
+	readers := make([]io.Reader, 0, len(f.extents))
+	for _, e := range f.extents {
+		readers = append(readers, io.NewSectionReader(img.f, int64(e.block)*img.blockSize, e.size))
+	}
+	return io.MultiReader(readers...)
+}
+
+func (img *Image) read() error {
+	var primary, joliet *volume
+	buf := make([]byte, sectorSize)
+	for i := 0; i < maxDescriptors; i++ {
+		if _, err := img.f.ReadAt(buf, int64(firstDescriptor+i)*sectorSize); err != nil {
+			return fmt.Errorf("reading volume descriptor: %v", err)
+		}
+		if string(buf[1:6]) != "CD001" {
+			return errors.New("no ISO 9660 image")
+		}
+		typ := buf[0]
+		if typ == descTerminator {
+			break
+		}
+		v := &volume{
+			root:      append([]byte(nil), buf[156:156+34]...),
+			blockSize: int64(binary.LittleEndian.Uint16(buf[128:130])),
+		}
+		if v.blockSize < 512 || v.blockSize > sectorSize || v.blockSize&(v.blockSize-1) != 0 {
+			return fmt.Errorf("invalid logical block size %d", v.blockSize)
+		}
+		switch {
+		case typ == descPrimary && primary == nil:
+			primary = v
+		case typ == descSupplementary && joliet == nil && isJoliet(buf[88:91]):
+			joliet = v
+		}
+	}
+	if primary == nil {
+		return errors.New("no primary volume descriptor")
+	}
+
+	vol := primary
+	img.blockSize = primary.blockSize
+	root, err := img.readDir(recordExtent(primary.root))
+	if err != nil {
+		return err
+	}
+	if len(root) > 0 {
+		// the "." entry of the root directory tells whether
+		// the System Use Sharing Protocol (Rock Ridge) is used
+		img.susp, img.suspLen = suspStart(systemUse(root[0]))
+	}
+	if !img.susp && joliet != nil {
+		vol = joliet
+		img.blockSize = joliet.blockSize
+		img.joliet = true
+	}
+	img.dirs = make(map[uint32]bool)
+	return img.walk(recordExtent(vol.root), "", 0)
+}
+
+// isJoliet checks the escape sequences of a supplementary volume
+// descriptor for the UCS-2 levels of Joliet.
+func isJoliet(esc []byte) bool {
+	return esc[0] == '%' && esc[1] == '/' && (esc[2] == '@' || esc[2] == 'C' || esc[2] == 'E')
+}
+
+func recordExtent(rec []byte) extent {
+	return extent{
+		block: binary.LittleEndian.Uint32(rec[2:6]),
+		size:  int64(binary.LittleEndian.Uint32(rec[10:14])),
+	}
+}
+
+// readDir returns the directory records of the directory e.
+func (img *Image) readDir(e extent) ([][]byte, error) {
+	if e.size > 64*1024*1024 {
+		return nil, fmt.Errorf("directory too large (%d bytes)", e.size)
+	}
+	data := make([]byte, e.size)
+	if _, err := img.f.ReadAt(data, int64(e.block)*img.blockSize); err != nil {
+		return nil, fmt.Errorf("reading directory at block %d: %v", e.block, err)
+	}
+
+	var records [][]byte
+	for off := 0; off < len(data); {
+		n := int(data[off])
+		if n == 0 {
+			// records do not cross sector boundaries, the
+			// rest of the sector is padding
+			off = (off/sectorSize + 1) * sectorSize
+			continue
+		}
+		if n < 34 || off+n > len(data) || 33+int(data[off+32]) > n {
+			return nil, fmt.Errorf("invalid directory record at block %d", e.block)
+		}
+		records = append(records, data[off:off+n])
+		off += n
+	}
+	return records, nil
+}
+
+// systemUse returns the system use area of the directory record rec.
+func systemUse(rec []byte) []byte {
+	start := 33 + int(rec[32])
+	if start%2 != 0 {
+		start++
+	}
+	if start > len(rec) {
+		return nil
+	}
+	return rec[start:]
+}
+
+// suspStart checks for the SP entry of the System Use Sharing
+// Protocol and returns the number of bytes to skip in every system
+// use area.
+func suspStart(su []byte) (bool, int) {
+	if len(su) >= 7 && su[0] == 'S' && su[1] == 'P' && su[4] == 0xbe && su[5] == 0xef {
+		return true, int(su[6])
+	}
+	return false, 0
+}
+
+// rockRidge contains the entries of a system use area relevant here.
+type rockRidge struct {
+	name    string
+	hasName bool
+	// the directory was relocated to child (CL)
+	child    uint32
+	hasChild bool
+	// the entry is a relocated directory (RE)
+	relocated bool
+	// the entry is a symlink (SL)
+	symlink bool
+}
+
+// parseRockRidge reads the Rock Ridge entries of the system use
+// area su, following continuation areas.
+func (img *Image) parseRockRidge(su []byte) (rockRidge, error) {
+	var rr rockRidge
+	if img.suspLen > len(su) {
+		return rr, nil
+	}
+	su = su[img.suspLen:]
+	for areas := 0; ; areas++ {
+		var next *extent
+		var nextOff int64
+		for len(su) >= 4 {
+			sig, n := string(su[0:2]), int(su[2])
+			if n < 4 || n > len(su) {
+				break
+			}
+			data := su[4:n]
+			switch sig {
+			case "NM":
+				if len(data) >= 1 {
+					rr.name += string(data[1:])
+					rr.hasName = true
+				}
+			case "CL":
+				if len(data) >= 4 {
+					rr.child = binary.LittleEndian.Uint32(data[0:4])
+					rr.hasChild = true
+				}
+			case "RE":
+				rr.relocated = true
+			case "SL":
+				rr.symlink = true
+			case "CE":
+				if len(data) >= 24 {
+					next = &extent{
+						block: binary.LittleEndian.Uint32(data[0:4]),
+						size:  int64(binary.LittleEndian.Uint32(data[16:20])),
+					}
+					nextOff = int64(binary.LittleEndian.Uint32(data[8:12]))
+				}
+			case "ST":
+				su = nil
+			}
+			if su == nil {
+				break
+			}
+			su = su[n:]
+		}
+		if next == nil {
+			return rr, nil
+		}
+		if areas > 16 || next.size > sectorSize {
+			return rr, errors.New("invalid continuation area")
+		}
+		su = make([]byte, next.size)
+		if _, err := img.f.ReadAt(su, int64(next.block)*img.blockSize+nextOff); err != nil {
+			return rr, fmt.Errorf("reading continuation area: %v", err)
+		}
+	}
+}
+
+// recordName returns the name of the directory record rec, or "" for
+// the entries of the directory itself and its parent.
+func (img *Image) recordName(rec []byte, rr rockRidge) string {
+	id := rec[33 : 33+int(rec[32])]
+	if len(id) == 1 && (id[0] == 0 || id[0] == 1) {
+		return ""
+	}
+	if rr.hasName {
+		return rr.name
+	}
+
+	var name string
+	if img.joliet {
+		u := make([]uint16, len(id)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(id[2*i:])
+		}
+		name = string(utf16.Decode(u))
+	} else {
+		name = strings.ToLower(string(id))
+	}
+	// strip the version and the dot of names without extension
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+func (img *Image) walk(dir extent, prefix string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%s: directories nested too deeply", prefix)
+	}
+	if img.dirs[dir.block] {
+		return fmt.Errorf("%s: directory loop", prefix)
+	}
+	img.dirs[dir.block] = true
+	records, err := img.readDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var multi *File
+	for _, rec := range records {
+		var rr rockRidge
+		if img.susp {
+			if rr, err = img.parseRockRidge(systemUse(rec)); err != nil {
+				return err
+			}
+		}
+		name := img.recordName(rec, rr)
+		if name == "" || rr.relocated || rr.symlink {
+			continue
+		}
+		if name == "." || name == ".." || strings.ContainsRune(name, '/') || strings.ContainsRune(name, 0) {
+			return fmt.Errorf("%s: invalid file name %q", prefix, name)
+		}
+		full := path.Join(prefix, name)
+		e := recordExtent(rec)
+
+		if rec[25]&flagDirectory != 0 || rr.hasChild {
+			if rr.hasChild {
+				// the size is in the "." entry of the
+				// relocated directory
+				child, err := img.readDir(extent{block: rr.child, size: img.blockSize})
+				if err != nil || len(child) == 0 {
+					return fmt.Errorf("%s: invalid relocated directory", full)
+				}
+				e = recordExtent(child[0])
+			}
+			if err := img.walk(e, full, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// files larger than 4 GB are split into several
+		// records of the same name
+		if multi != nil && multi.Name == full {
+			multi.extents = append(multi.extents, e)
+			multi.Size += e.size
+		} else {
+			if len(img.files) >= maxFiles {
+				return errors.New("too many files")
+			}
+			multi = &File{
+				Name:    full,
+				Size:    e.size,
+				ModTime: recordTime(rec[18:25]),
+				extents: []extent{e},
+			}
+			img.files = append(img.files, multi)
+		}
+		if rec[25]&flagMultiExtent == 0 {
+			multi = nil
+		}
+	}
+	return nil
+}
+
+// recordTime converts the recording date of a directory record.
+func recordTime(b []byte) time.Time {
+	// offset from GMT in 15 minute intervals
+	loc := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]),
+		int(b[3]), int(b[4]), int(b[5]), 0, loc)
+}
@@ -4,13 +4,23 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
 	"github.com/thkukuk/rpm2docserv/pkg/tag"
 	"golang.org/x/text/language"
 	"github.com/knqyf263/go-rpm-version"
 )
 
+// RawSuffix is the suffix of the raw manpages in the serving
+// directory, which defines their compression.
+var RawSuffix = ".gz"
+
+// TextSuffix is the suffix of the plain text manpages, which are
+// stored gzip compressed like the HTML pages.
+const TextSuffix = ".txt"
+
 type PkgMeta struct {
 	Filename  string
 	Sourcepkg string
@@ -19,13 +29,62 @@ type PkgMeta struct {
 	// Version is used by the templates when rendering.
 	Version version.Version
 
+	// Arch is the architecture of the binary package, e.g. x86_64
+	// or noarch.
+	Arch string
+
+	// ArchSpecific maps the serving path of manpages, which are
+	// not part of the builds of this package for all architectures,
+	// to the architectures containing them.
+	ArchSpecific map[string][]string
+
+	// Alternatives maps the serving path of manpages, which are
+	// installed with update-alternatives, to all binary packages
+	// providing them.
+	Alternatives map[string][]string
+
+	// Aliases maps the serving path of manpages, which are links
+	// to a manpage of another package, to the serving path of the
+	// link target.
+	Aliases map[string]string
+
 	// Product is the product in which this binary package was found.
 	Product string
 
+	// Older is set for an older version of the binary package,
+	// which is kept because of keep_versions. Its manpages are
+	// served in an own directory, see Dir.
+	Older bool
+
 	// Track list of manpages
 	ManpageList []string
 }
 
+// Dir returns the directory of the package below the product in the
+// serving directory: the name of the binary package or, for older
+// versions, <binarypkg>@<version>, see DirVersion.
+func (p *PkgMeta) Dir() string {
+	if p.Older {
+		return p.Binarypkg + "@" + p.DirVersion()
+	}
+	return p.Binarypkg
+}
+
+// DirVersion returns the version of the package as used in directory
+// names and URLs: <version>-<release>, prefixed with <epoch>! if the
+// epoch is set. Unlike the colon of Version.String, "!" is neither
+// special in paths nor part of RPM or Debian versions.
+func (p *PkgMeta) DirVersion() string {
+	v := p.Version.Version()
+	if r := p.Version.Release(); r != "" {
+		v += "-" + r
+	}
+	if e := p.Version.Epoch(); e > 0 {
+		v = strconv.Itoa(e) + "!" + v
+	}
+	return v
+}
+
 func (p *PkgMeta) SameBinary(o *PkgMeta) bool {
 	if p.Binarypkg == o.Binarypkg {
 		return true
@@ -53,9 +112,9 @@ type Meta struct {
 	LanguageTag language.Tag
 }
 
-// FromManPath constructs a manpage, gathering details from path (relative underneath /usr/share/man).
+// FromManPath constructs a manpage, gathering details from path (relative to the root of the manpages, e.g. underneath /usr/share/man).
 func FromManPath(path string, p *PkgMeta) (*Meta, error) {
-	// man pages are in /usr/share/man/(<lang>/|)man<section>/<name>.<section>.gz
+	// man pages are in /usr/share/man/(<lang>/|)man<section>/<name>.<section>[.gz|.xz|...]
 
 	lang := "C"
 
@@ -89,12 +148,11 @@ func FromManPath(path string, p *PkgMeta) (*Meta, error) {
 		return nil, fmt.Errorf("Unexpected path format %q", path)
 	}
 
-	if !strings.HasSuffix(parts[1], ".gz") {
-		parts[1] = parts[1] + ".gz"
-	}
+	// the manpage can be compressed with any method or not at all
+	parts[1] = decompress.TrimSuffix(parts[1])
 
 	section := strings.TrimPrefix(parts[0], "man")
-	re := regexp.MustCompile(fmt.Sprintf(`\.%s([^.]*)\.gz$`, section))
+	re := regexp.MustCompile(fmt.Sprintf(`\.%s([^.]*)$`, section))
 	matches := re.FindStringSubmatch(parts[1])
 	if matches == nil {
 		return nil, fmt.Errorf("file name (%q) does not match regexp %v", parts[1], re)
@@ -104,7 +162,7 @@ func FromManPath(path string, p *PkgMeta) (*Meta, error) {
 	}
 
 	return &Meta{
-		Name:        strings.TrimSuffix(parts[1], "."+section+".gz"),
+		Name:        strings.TrimSuffix(parts[1], "."+section),
 		Package:     p,
 		Section:     strings.ToLower(section),
 		Language:    lang,
@@ -121,7 +179,7 @@ func FromServingPath(servingDir, path string) (*Meta, error) {
 		return nil, fmt.Errorf("Unexpected path format %q", relpath)
 	}
 
-	base := strings.TrimSuffix(filepath.Base(path), ".gz")
+	base := strings.TrimSuffix(filepath.Base(path), RawSuffix)
 	// the first part can contain dots, so we need to “split from the right”
 	// TODO: this can be implemented more efficiently
 	allbparts := strings.Split(base, ".")
@@ -160,18 +218,18 @@ func (m *Meta) String() string {
 }
 
 func (m *Meta) ServingPath() string {
-	return m.Package.Product + "/" + m.Package.Binarypkg + "/" + m.Name + "." + m.Section + "." + m.Language
+	return m.Package.Product + "/" + m.Package.Dir() + "/" + m.Name + "." + m.Section + "." + m.Language
 }
 
 // RawPath returns the path to access the raw manpage equivalent of
 // what is currently being served, i.e. locked to the current
 // language.
 func (m *Meta) RawPath() string {
-	return m.Package.Product + "/" + m.Package.Binarypkg + "/" + m.Name + "." + m.Section + "." + m.Language + ".gz"
+	return m.ServingPath() + RawSuffix
 }
 
 func (m *Meta) PermaLink() string {
-	return m.Package.Product + "/" + m.Package.Binarypkg + "/" + m.Name + "." + m.Section
+	return m.Package.Product + "/" + m.Package.Dir() + "/" + m.Name + "." + m.Section
 }
 
 func (m *Meta) MainSection() string {
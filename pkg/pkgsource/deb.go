@@ -0,0 +1,44 @@
+package pkgsource
+
+import (
+	"strings"
+
+	"github.com/thkukuk/rpm2docserv/pkg/deb"
+)
+
+// Deb reads Debian binary packages.
+type Deb struct{}
+
+func (Deb) IsPackage(fn string) bool {
+	return strings.HasSuffix(fn, ".deb")
+}
+
+func (Deb) ReadPackage(fn string) (*Package, error) {
+	d, err := deb.ReadPackage(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &Package{
+		Name:    d.Name,
+		Version: d.Version,
+		Arch:    d.Arch,
+		Source:  d.Source,
+		Files:   make([]File, 0, len(d.Files)),
+	}
+	for _, f := range d.Files {
+		pkg.Files = append(pkg.Files, File{
+			Name:   f.Name,
+			Mode:   f.Mode,
+			Linkto: f.Linkto,
+		})
+	}
+	for _, s := range d.Scripts {
+		pkg.Scripts = append(pkg.Scripts, Script(s))
+	}
+	return pkg, nil
+}
+
+func (Deb) Extract(fn string, destDir string, want func(name string) bool) error {
+	return deb.Extract(fn, destDir, want)
+}
@@ -0,0 +1,199 @@
+package pkgsource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/thkukuk/rpm2docserv/pkg/unpack"
+)
+
+// Directory reads manual pages, which are not packaged at all, from a
+// directory tree with the layout of a man hierarchy (man1/,
+// de/man8/, ...). Every package found in it is named
+// <root>/#directory/<name>, see ReadDirectory.
+type Directory struct{}
+
+const directoryDir = "/#directory/"
+
+// DirectoryPackage is a synthetic package of a directory tree.
+type DirectoryPackage struct {
+	*Package
+	// Path is the name of the package used by Directory
+	Path string
+	// Digest is the SHA-256 over the names, sizes, modification
+	// times and link targets of all files of the package.
+	Digest string
+}
+
+type directoryPackage struct {
+	pkg *Package
+	// maps the file names of the package to the files in the tree
+	files map[string]string
+}
+
+// the packages read by ReadDirectory, indexed by their path
+var directories struct {
+	sync.Mutex
+	pkgs map[string]*directoryPackage
+}
+
+// ReadDirectory reads the directory tree root and creates a package
+// for every package name returned by packageOf for the paths of the
+// files relative to root. Files for which it returns "" are ignored.
+// The files of the packages are installed below prefix, which should
+// be the root of the manpages (e.g. "/usr/share/man").
+func ReadDirectory(root string, prefix string, version string, packageOf func(rel string) string) ([]DirectoryPackage, error) {
+	root = filepath.Clean(root)
+	byName := make(map[string]*directoryPackage)
+	digests := make(map[string][]string)
+
+	err := filepath.WalkDir(root, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fn)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := packageOf(rel)
+		if name == "" {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f := File{
+			Name: path.Join(prefix, rel),
+			Mode: fi.Mode(),
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if f.Linkto, err = os.Readlink(fn); err != nil {
+				return err
+			}
+			// absolute links into the tree are relative to prefix
+			if l, err := filepath.Rel(root, f.Linkto); err == nil && filepath.IsAbs(f.Linkto) && !strings.HasPrefix(l, "..") {
+				f.Linkto = path.Join(prefix, filepath.ToSlash(l))
+			}
+		} else if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		dp, ok := byName[name]
+		if !ok {
+			dp = &directoryPackage{
+				pkg: &Package{
+					Name:    name,
+					Version: version,
+					Arch:    "noarch",
+					Source:  name,
+				},
+				files: make(map[string]string),
+			}
+			byName[name] = dp
+		}
+		dp.pkg.Files = append(dp.pkg.Files, f)
+		dp.files[f.Name] = fn
+		digests[name] = append(digests[name], fmt.Sprintf("%s\x00%d\x00%d\x00%s", f.Name, fi.Size(), fi.ModTime().UnixNano(), f.Linkto))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]DirectoryPackage, 0, len(byName))
+	directories.Lock()
+	defer directories.Unlock()
+	if directories.pkgs == nil {
+		directories.pkgs = make(map[string]*directoryPackage)
+	}
+	for name, dp := range byName {
+		// WalkDir walks in lexical order, the digest is stable
+		sum := sha256.Sum256([]byte(strings.Join(digests[name], "\x00")))
+		p := DirectoryPackage{
+			Package: dp.pkg,
+			Path:    filepath.Join(root, directoryDir, name),
+			Digest:  hex.EncodeToString(sum[:]),
+		}
+		directories.pkgs[p.Path] = dp
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+func directoryPackageOf(fn string) (*directoryPackage, error) {
+	directories.Lock()
+	defer directories.Unlock()
+	dp, ok := directories.pkgs[fn]
+	if !ok {
+		return nil, fmt.Errorf("%s: directory was not read", fn)
+	}
+	return dp, nil
+}
+
+func (Directory) IsPackage(fn string) bool {
+	return strings.Contains(fn, directoryDir)
+}
+
+func (Directory) ReadPackage(fn string) (*Package, error) {
+	dp, err := directoryPackageOf(fn)
+	if err != nil {
+		return nil, err
+	}
+	return dp.pkg, nil
+}
+
+// Extract copies the files of the package from the directory tree.
+func (Directory) Extract(fn string, destDir string, want func(name string) bool) error {
+	dp, err := directoryPackageOf(fn)
+	if err != nil {
+		return err
+	}
+	for _, f := range dp.pkg.Files {
+		if !want(f.Name) {
+			continue
+		}
+		dst, err := unpack.SecurePath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.Mode&fs.ModeSymlink != 0 {
+			err = unpack.WriteSymlink(dst, f.Name, f.Linkto)
+		} else {
+			err = copyFile(dp.files[f.Name], dst, f.Mode)
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string, mode fs.FileMode) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return unpack.WriteFile(dst, f, mode, fi.ModTime())
+}
@@ -0,0 +1,173 @@
+package pkgsource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/thkukuk/rpm2docserv/pkg/rpm"
+	"github.com/thkukuk/rpm2docserv/pkg/rpmdb"
+	"github.com/thkukuk/rpm2docserv/pkg/unpack"
+)
+
+// Installed reads the packages installed in a root directory (the
+// running system or e.g. a mounted image) from its rpm database. They
+// have no package file, the files are read from the root directory
+// instead. Such a package is named
+// <root>/#installed/<name>-<version>.<arch>, see ReadInstalled.
+type Installed struct{}
+
+const installedDir = "/#installed/"
+
+// InstalledPackage is a package of the rpm database.
+type InstalledPackage struct {
+	*Package
+	// Path is the name of the package used by Installed
+	Path string
+	// Digest is the SHA-256 of the header in the rpm database, it
+	// changes with every update of the package.
+	Digest string
+}
+
+// the packages read by ReadInstalled, indexed by their path
+var installed struct {
+	sync.Mutex
+	roots map[string]bool
+	pkgs  map[string]*Package
+}
+
+// ReadInstalled reads all packages from the rpm database below root.
+func ReadInstalled(root string) ([]InstalledPackage, error) {
+	root = filepath.Clean(root)
+	blobs, err := rpmdb.Headers(root)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]InstalledPackage, 0, len(blobs))
+	for _, blob := range blobs {
+		hdr, err := rpm.ParseHeaderBlob(blob)
+		if err != nil {
+			return nil, err
+		}
+		p, err := hdr.Package()
+		if err != nil {
+			return nil, err
+		}
+		if p.Name == "gpg-pubkey" {
+			// imported keys, no real package
+			continue
+		}
+		pkg := fromRPM(p)
+		sum := sha256.Sum256(blob)
+		result = append(result, InstalledPackage{
+			Package: pkg,
+			Path:    filepath.Join(root, installedDir, pkg.Name+"-"+pkg.Version+"."+pkg.Arch),
+			Digest:  hex.EncodeToString(sum[:]),
+		})
+	}
+
+	installed.Lock()
+	defer installed.Unlock()
+	if installed.pkgs == nil {
+		installed.roots = make(map[string]bool)
+		installed.pkgs = make(map[string]*Package)
+	}
+	installed.roots[root] = true
+	for _, p := range result {
+		installed.pkgs[p.Path] = p.Package
+	}
+	return result, nil
+}
+
+// splitInstalled returns the root directory of the installed package fn.
+func splitInstalled(fn string) string {
+	root, _, _ := strings.Cut(fn, installedDir)
+	if root == "" {
+		return "/"
+	}
+	return root
+}
+
+func installedPackage(fn string) (*Package, error) {
+	root := splitInstalled(fn)
+
+	installed.Lock()
+	loaded := installed.roots[root]
+	installed.Unlock()
+	if !loaded {
+		if _, err := ReadInstalled(root); err != nil {
+			return nil, err
+		}
+	}
+
+	installed.Lock()
+	defer installed.Unlock()
+	pkg, ok := installed.pkgs[fn]
+	if !ok {
+		return nil, fmt.Errorf("%s: not installed", filepath.Base(fn))
+	}
+	return pkg, nil
+}
+
+func (Installed) IsPackage(fn string) bool {
+	return strings.Contains(fn, installedDir)
+}
+
+func (Installed) ReadPackage(fn string) (*Package, error) {
+	return installedPackage(fn)
+}
+
+// Extract copies the files of the package from its root directory.
+// Symlinks are created as recorded in the rpm database. Files missing
+// in the root directory (e.g. installed with --excludedocs) or which
+// cannot be read are skipped.
+func (Installed) Extract(fn string, destDir string, want func(name string) bool) error {
+	pkg, err := installedPackage(fn)
+	if err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(splitInstalled(fn))
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	for _, f := range pkg.Files {
+		if f.Ghost || f.Mode.IsDir() || !want(f.Name) {
+			continue
+		}
+		dst, err := unpack.SecurePath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.Mode&fs.ModeSymlink != 0 {
+			err = unpack.WriteSymlink(dst, f.Name, f.Linkto)
+		} else {
+			err = copyInstalled(root, f, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func copyInstalled(root *os.Root, f File, dst string) error {
+	src, err := root.Open(strings.TrimPrefix(f.Name, "/"))
+	if err != nil {
+		// reported as missing manpage later
+		return nil
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	return unpack.WriteFile(dst, src, f.Mode, fi.ModTime())
+}
@@ -0,0 +1,162 @@
+package pkgsource
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thkukuk/rpm2docserv/pkg/iso9660"
+	"github.com/thkukuk/rpm2docserv/pkg/rpm"
+)
+
+// ISO reads the RPMs of ISO 9660 images (e.g. installation media)
+// without mounting them. A package in an image is named
+// <image>/#iso/<path in the image>, see ListISO.
+type ISO struct{}
+
+const isoDir = "/#iso/"
+
+// the opened images, indexed by their file name
+var images struct {
+	sync.Mutex
+	m map[string]*isoImage
+}
+
+type isoImage struct {
+	img   *iso9660.Image
+	files map[string]*iso9660.File
+}
+
+// IsImage returns true if fn is an ISO 9660 image, judging by the name.
+func IsImage(fn string) bool {
+	return strings.HasSuffix(fn, ".iso")
+}
+
+// openImage returns the image fn, it is opened only once.
+func openImage(fn string) (*isoImage, error) {
+	images.Lock()
+	defer images.Unlock()
+	if img, ok := images.m[fn]; ok {
+		return img, nil
+	}
+
+	img, err := iso9660.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	result := &isoImage{
+		img:   img,
+		files: make(map[string]*iso9660.File),
+	}
+	for _, f := range img.Files() {
+		result.files[f.Name] = f
+	}
+	if images.m == nil {
+		images.m = make(map[string]*isoImage)
+	}
+	images.m[fn] = result
+	return result, nil
+}
+
+// ListISO returns the names of all packages in the image fn.
+func ListISO(fn string) ([]string, error) {
+	img, err := openImage(fn)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, f := range img.img.Files() {
+		if (RPM{}).IsPackage(f.Name) {
+			result = append(result, fn+isoDir+f.Name)
+		}
+	}
+	return result, nil
+}
+
+// isoFile returns the image and the file of the package fn.
+func isoFile(fn string) (*isoImage, *iso9660.File, error) {
+	image, name, _ := strings.Cut(fn, isoDir)
+	img, err := openImage(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, ok := img.files[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: not found in %s", name, image)
+	}
+	return img, f, nil
+}
+
+func (ISO) IsPackage(fn string) bool {
+	return strings.Contains(fn, isoDir) && (RPM{}).IsPackage(fn)
+}
+
+func (ISO) ReadPackage(fn string) (*Package, error) {
+	img, f, err := isoFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	r, err := rpm.NewReader(img.img.Open(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	hdr, err := r.Header.Package()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return fromRPM(hdr), nil
+}
+
+func (ISO) Extract(fn string, destDir string, want func(name string) bool) error {
+	img, f, err := isoFile(fn)
+	if err != nil {
+		return err
+	}
+	r, err := rpm.NewReader(img.img.Open(f))
+	if err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	return r.Extract(destDir, want)
+}
+
+// Open opens the package file fn for reading, which can be a file in
+// an image, too.
+func Open(fn string) (io.ReadCloser, error) {
+	if (ISO{}).IsPackage(fn) {
+		img, f, err := isoFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(img.img.Open(f)), nil
+	}
+	return os.Open(fn)
+}
+
+// Stat returns the size and modification time of the package file
+// fn, which can be a file in an image, too.
+func Stat(fn string) (fs.FileInfo, error) {
+	if (ISO{}).IsPackage(fn) {
+		_, f, err := isoFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		return isoFileInfo{f}, nil
+	}
+	return os.Stat(fn)
+}
+
+type isoFileInfo struct {
+	f *iso9660.File
+}
+
+func (fi isoFileInfo) Name() string       { return path.Base(fi.f.Name) }
+func (fi isoFileInfo) Size() int64        { return fi.f.Size }
+func (fi isoFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi isoFileInfo) ModTime() time.Time { return fi.f.ModTime }
+func (fi isoFileInfo) IsDir() bool        { return false }
+func (fi isoFileInfo) Sys() any           { return nil }
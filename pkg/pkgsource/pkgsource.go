@@ -0,0 +1,115 @@
+// Package pkgsource abstracts the package formats from which
+// manual pages can be extracted.
+package pkgsource
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// File is an entry of the file list of a package.
+type File struct {
+	// Name is the absolute path of the file, e.g. "/usr/bin/bash"
+	Name string
+	Mode fs.FileMode
+	// Linkto is the target of a symlink or, for formats which
+	// record it, the file a hardlink points to.
+	Linkto string
+	// Ghost files are listed, but not part of the payload
+	// (rpm %ghost, e.g. for update-alternatives).
+	Ghost bool
+}
+
+// Script is an installation script of a package.
+type Script struct {
+	// Name is the type of the script, e.g. "postinstall" or
+	// "postinst"
+	Name        string
+	Interpreter string
+	Body        string
+}
+
+// Package is the format independent metadata of a binary package.
+type Package struct {
+	Name string
+	// Version is the full version including the epoch and the
+	// release in the notation of the package format, e.g.
+	// "1:2.3-1.1".
+	Version string
+	Arch    string
+	// Source is the name of the source package
+	Source string
+
+	Files   []File
+	Scripts []Script
+}
+
+// PackageSource is a package format, e.g. RPM or Debian packages.
+type PackageSource interface {
+	// IsPackage returns true if fn is a package file of this
+	// format, just judging by the name.
+	IsPackage(fn string) bool
+
+	// ReadPackage reads the metadata, the file list and the
+	// scripts of the package file fn.
+	ReadPackage(fn string) (*Package, error)
+
+	// Extract writes all files of the package fn, for which want
+	// returns true, below destDir. Nothing is written outside of
+	// destDir.
+	Extract(fn string, destDir string, want func(name string) bool) error
+}
+
+// Sources are all supported package formats. ISO must come before
+// RPM, the names of the packages in an image end with ".rpm", too.
+var Sources = []PackageSource{ISO{}, RPM{}, Deb{}, Installed{}, Directory{}}
+
+// ForFile returns the package format of fn or nil, if it is no
+// supported package.
+func ForFile(fn string) PackageSource {
+	for _, src := range Sources {
+		if src.IsPackage(fn) {
+			return src
+		}
+	}
+	return nil
+}
+
+// ReadPackage reads the package fn of any supported format.
+func ReadPackage(fn string) (*Package, error) {
+	src := ForFile(fn)
+	if src == nil {
+		return nil, fmt.Errorf("%s: unsupported package format", fn)
+	}
+	return src.ReadPackage(fn)
+}
+
+// List returns all packages of any supported format below dir,
+// including the packages in ISO images. Directories for which skip
+// returns true are not searched.
+func List(dir string, skip func(path string) bool) ([]string, error) {
+	var result []string
+	err := filepath.WalkDir(dir, func(path string, di fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if di.IsDir() {
+			if skip != nil && skip(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if IsImage(path) {
+			pkgs, err := ListISO(path)
+			if err != nil {
+				return err
+			}
+			result = append(result, pkgs...)
+		} else if ForFile(path) != nil {
+			result = append(result, path)
+		}
+		return nil
+	})
+	return result, err
+}
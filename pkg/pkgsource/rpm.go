@@ -0,0 +1,61 @@
+package pkgsource
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/thkukuk/rpm2docserv/pkg/rpm"
+)
+
+// RPM reads RPM packages.
+type RPM struct{}
+
+func (RPM) IsPackage(fn string) bool {
+	return strings.HasSuffix(fn, ".rpm")
+}
+
+func (RPM) ReadPackage(fn string) (*Package, error) {
+	hdr, err := rpm.ReadPackage(fn)
+	if err != nil {
+		return nil, err
+	}
+	return fromRPM(hdr), nil
+}
+
+// fromRPM converts the header data of a RPM
+func fromRPM(hdr *rpm.Package) *Package {
+	pkg := &Package{
+		Name:    hdr.Name,
+		Version: rpm.EVR(hdr.Epoch, hdr.Version, hdr.Release),
+		Arch:    hdr.Arch,
+		Files:   make([]File, 0, len(hdr.Files)),
+	}
+	pkg.Source, _, _, _, _ = rpm.SplitRPMname(hdr.SourceRPM)
+	for _, f := range hdr.Files {
+		pkg.Files = append(pkg.Files, File{
+			Name:   f.Name,
+			Mode:   f.Mode,
+			Linkto: f.Linkto,
+			Ghost:  f.Flags&rpm.FileGhost != 0,
+		})
+	}
+	for _, s := range hdr.Scripts {
+		pkg.Scripts = append(pkg.Scripts, Script(s))
+	}
+	return pkg
+}
+
+func (RPM) Extract(fn string, destDir string, want func(name string) bool) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := rpm.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	return r.Extract(destDir, want)
+}
@@ -23,6 +23,10 @@ type IndexEntry struct {
 	Binarypkg string // TODO: sort by popcon, TODO: use a string pool
 	Section   string // TODO: use a string pool
 	Language  string // TODO: type: would it make sense to use language.Tag?
+	Version   string // version of the binary package (epoch:version-release)
+	// MissingSuffixes are the suffixes of Index.FormatSuffixes,
+	// which could not be rendered for this manpage
+	MissingSuffixes []string
 }
 
 func (e IndexEntry) ServingPath(suffix string) string {
@@ -35,6 +39,11 @@ type Index struct {
 	Langs          []string
 	Sections       []string
 	ProductMapping map[string]string
+	// RawSuffix is the suffix of the raw manpages, e.g. ".gz"
+	RawSuffix      string
+	// FormatSuffixes are the suffixes of the formats rendered
+	// besides HTML and the raw manpages, e.g. ".txt" and ".pdf"
+	FormatSuffixes []string
 }
 
 func bestLanguageMatch(t []language.Tag, options []IndexEntry) IndexEntry {
@@ -285,6 +294,17 @@ func (e *NotFoundError) Error() string {
 	return "No such man page"
 }
 
+// formatSuffix returns the suffix of i.FormatSuffixes path ends with.
+// Suffixes of formats which are not rendered are not found.
+func (i Index) formatSuffix(path string) string {
+	for _, s := range i.FormatSuffixes {
+		if strings.HasSuffix(path, s) {
+			return s
+		}
+	}
+	return ""
+}
+
 func (i Index) Redirect(r *http.Request) (string, error) {
 	path := r.URL.Path
 
@@ -294,14 +314,26 @@ func (i Index) Redirect(r *http.Request) (string, error) {
 		return "", &NotFoundError{}
 	}
 
-	suffix := ".html"
-	// If a raw manpage was requested, redirect to raw, not HTML
-	if strings.HasSuffix(path, ".gz") && !strings.HasSuffix(path, ".html.gz") {
-		suffix = ".gz"
+	rawSuffix := i.RawSuffix
+	if rawSuffix == "" {
+		rawSuffix = ".gz"
 	}
-	for strings.HasSuffix(path, ".html") || strings.HasSuffix(path, ".gz") {
+
+	suffix := ".html"
+	// If another format was requested, redirect to it. They are
+	// stored compressed like the HTML pages.
+	format := i.formatSuffix(strings.TrimSuffix(path, ".gz"))
+	if format != "" {
+		suffix = format
+	} else if strings.HasSuffix(path, rawSuffix) && !strings.HasSuffix(path, ".html"+rawSuffix) {
+		// If a raw manpage was requested, redirect to raw, not HTML
+		suffix = rawSuffix
+	}
+	for strings.HasSuffix(path, ".html") || strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, rawSuffix) || i.formatSuffix(path) != "" {
+		path = strings.TrimSuffix(path, rawSuffix)
 		path = strings.TrimSuffix(path, ".gz")
 		path = strings.TrimSuffix(path, ".html")
+		path = strings.TrimSuffix(path, i.formatSuffix(path))
 	}
 
 	// Parens are converted into dots, so that “i3(1)” becomes
@@ -363,6 +395,10 @@ func (i Index) Redirect(r *http.Request) (string, error) {
 			Choices:  choices,
 		        Products: i.ProductNames}
 	}
+	if slices.Contains(filtered[0].MissingSuffixes, suffix) {
+		log.Printf("Not found: Url %q, %s not rendered", r.URL.Path, suffix)
+		return "", &NotFoundError{Manpage: name}
+	}
 	log.Printf("Found: Query %q -> Url %q", r.URL.Path, filtered[0].ServingPath(suffix))
 
 	return filtered[0].ServingPath(suffix), nil
@@ -390,16 +426,20 @@ func IndexFromProto(paths []string) (Index, error) {
 	for _, e := range idx.Entry {
 		name := strings.ToLower(e.Name)
 		index.Entries[name] = append(index.Entries[name], IndexEntry{
-			Name:      e.Name,
-			Product:   e.Suite,
-			Binarypkg: e.Binarypkg,
-			Section:   e.Section,
-			Language:  e.Language,
+			Name:            e.Name,
+			Product:         e.Suite,
+			Binarypkg:       e.Binarypkg,
+			Section:         e.Section,
+			Language:        e.Language,
+			Version:         e.Version,
+			MissingSuffixes: e.MissingSuffixes,
 		})
 	}
 	index.Langs = idx.Language
 	index.Sections = idx.Section
 	index.ProductMapping = idx.Suite
+	index.RawSuffix = idx.RawSuffix
+	index.FormatSuffixes = idx.FormatSuffixes
 
 	// old index files are not sorted
 	sort.Strings(index.Langs)
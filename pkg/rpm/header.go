@@ -0,0 +1,310 @@
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Tags of the signature and main header, see
+// https://rpm-software-management.github.io/rpm/manual/tags.html
+const (
+	TagName              = 1000
+	TagVersion           = 1001
+	TagRelease           = 1002
+	TagEpoch             = 1003
+	TagFileSizes         = 1028
+	TagFileModes         = 1030
+	TagFileLinkTos       = 1036
+	TagFileFlags         = 1037
+	TagArch              = 1022
+	TagPreIn             = 1023
+	TagPostIn            = 1024
+	TagPreUn             = 1025
+	TagPostUn            = 1026
+	TagOldFilenames      = 1027
+	TagSourceRPM         = 1044
+	TagPreInProg         = 1085
+	TagPostInProg        = 1086
+	TagPreUnProg         = 1087
+	TagPostUnProg        = 1088
+	TagDirIndexes        = 1116
+	TagBasenames         = 1117
+	TagDirnames          = 1118
+	TagPayloadFormat     = 1124
+	TagPayloadCompressor = 1125
+	TagPreTrans          = 1151
+	TagPostTrans         = 1152
+	TagPreTransProg      = 1153
+	TagPostTransProg     = 1154
+	TagLongFileSizes     = 5008
+	TagPayloadDigest     = 5092
+	TagPayloadDigestAlgo = 5093
+)
+
+// Data types of header entries
+const (
+	typeNull        = 0
+	typeChar        = 1
+	typeInt8        = 2
+	typeInt16       = 3
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+var (
+	leadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+const (
+	leadSize = 96
+
+	// Same limits as rpm uses itself, protects us from
+	// allocating insane amounts of memory for broken files.
+	maxIndexEntries = 0x0000ffff
+	maxStoreSize    = 256 * 1024 * 1024
+)
+
+type indexEntry struct {
+	Tag    int32
+	Type   uint32
+	Offset int32
+	Count  uint32
+}
+
+// Header is a parsed signature or main header of a RPM.
+type Header struct {
+	entries map[int]indexEntry
+	store   []byte
+
+	// raw contains the complete header as found in the file,
+	// starting with the header magic.
+	raw []byte
+}
+
+// readHeader reads a header structure from r. If pad is true,
+// the header is followed by padding to the next 8 byte boundary,
+// which is the case for the signature header.
+func readHeader(r io.Reader, pad bool) (*Header, error) {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, fmt.Errorf("reading header intro: %v", err)
+	}
+	if !bytes.Equal(intro[:4], headerMagic) {
+		return nil, errors.New("bad header magic")
+	}
+
+	nindex := binary.BigEndian.Uint32(intro[8:12])
+	hsize := binary.BigEndian.Uint32(intro[12:16])
+	if nindex > maxIndexEntries || hsize > maxStoreSize {
+		return nil, fmt.Errorf("header too large (%d entries, %d bytes)", nindex, hsize)
+	}
+
+	raw := make([]byte, 16+16*int(nindex)+int(hsize))
+	copy(raw, intro)
+	if _, err := io.ReadFull(r, raw[16:]); err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+
+	if pad {
+		if n := len(raw) % 8; n != 0 {
+			if _, err := io.CopyN(io.Discard, r, int64(8-n)); err != nil {
+				return nil, fmt.Errorf("reading header padding: %v", err)
+			}
+		}
+	}
+
+	return parseHeader(raw, 16, nindex, hsize)
+}
+
+// ParseHeaderBlob parses a main header as stored in the rpm database:
+// without the header magic, starting with the number of index entries.
+// Raw returns it with the magic added again.
+func ParseHeaderBlob(blob []byte) (*Header, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("header blob too short")
+	}
+	nindex := binary.BigEndian.Uint32(blob[0:4])
+	hsize := binary.BigEndian.Uint32(blob[4:8])
+	if nindex > maxIndexEntries || hsize > maxStoreSize {
+		return nil, fmt.Errorf("header too large (%d entries, %d bytes)", nindex, hsize)
+	}
+	size := 8 + 16*int(nindex) + int(hsize)
+	if len(blob) < size {
+		return nil, errors.New("header blob truncated")
+	}
+
+	raw := make([]byte, 8, 8+size)
+	copy(raw, headerMagic)
+	raw = append(raw, blob[:size]...)
+	return parseHeader(raw, 16, nindex, hsize)
+}
+
+func parseHeader(raw []byte, start int, nindex uint32, hsize uint32) (*Header, error) {
+	h := &Header{
+		entries: make(map[int]indexEntry, nindex),
+		store:   raw[start+16*int(nindex):],
+		raw:     raw,
+	}
+
+	for i := 0; i < int(nindex); i++ {
+		b := raw[start+16*i : start+16*(i+1)]
+		e := indexEntry{
+			Tag:    int32(binary.BigEndian.Uint32(b[0:4])),
+			Type:   binary.BigEndian.Uint32(b[4:8]),
+			Offset: int32(binary.BigEndian.Uint32(b[8:12])),
+			Count:  binary.BigEndian.Uint32(b[12:16]),
+		}
+		if e.Offset < 0 || uint32(e.Offset) > hsize {
+			return nil, fmt.Errorf("tag %d: offset %d outside of header store", e.Tag, e.Offset)
+		}
+		h.entries[int(e.Tag)] = e
+	}
+
+	return h, nil
+}
+
+// Has returns true if the header contains the tag.
+func (h *Header) Has(tag int) bool {
+	_, ok := h.entries[tag]
+	return ok
+}
+
+// Raw returns the header as found in the file, starting with the
+// header magic.
+func (h *Header) Raw() []byte {
+	return h.raw
+}
+
+func (h *Header) data(e indexEntry, size int) ([]byte, error) {
+	end := int(e.Offset) + size*int(e.Count)
+	if size*int(e.Count) < 0 || end > len(h.store) {
+		return nil, fmt.Errorf("tag %d: data outside of header store", e.Tag)
+	}
+	return h.store[e.Offset:end], nil
+}
+
+// strings returns count NUL terminated strings starting at offset.
+func (h *Header) strings(e indexEntry) ([]string, error) {
+	b := h.store[e.Offset:]
+	// every string needs at least its NUL byte
+	if int64(e.Count) > int64(len(b)) {
+		return nil, fmt.Errorf("tag %d: data outside of header store", e.Tag)
+	}
+	result := make([]string, 0, e.Count)
+	for i := uint32(0); i < e.Count; i++ {
+		end := bytes.IndexByte(b, 0)
+		if end < 0 {
+			return nil, fmt.Errorf("tag %d: unterminated string", e.Tag)
+		}
+		result = append(result, string(b[:end]))
+		b = b[end+1:]
+	}
+	return result, nil
+}
+
+// String returns the value of a string tag or an empty
+// string if the tag does not exist.
+func (h *Header) String(tag int) (string, error) {
+	e, ok := h.entries[tag]
+	if !ok {
+		return "", nil
+	}
+	switch e.Type {
+	case typeString, typeI18NString, typeStringArray:
+		// For I18N strings the first one is the untranslated
+		// version, which is all we want.
+		s, err := h.strings(indexEntry{Tag: e.Tag, Offset: e.Offset, Count: 1})
+		if err != nil {
+			return "", err
+		}
+		return s[0], nil
+	}
+	return "", fmt.Errorf("tag %d: unexpected type %d for string", tag, e.Type)
+}
+
+// StringArray returns the values of a string array tag or nil
+// if the tag does not exist.
+func (h *Header) StringArray(tag int) ([]string, error) {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil, nil
+	}
+	switch e.Type {
+	case typeString, typeStringArray, typeI18NString:
+		return h.strings(e)
+	}
+	return nil, fmt.Errorf("tag %d: unexpected type %d for string array", tag, e.Type)
+}
+
+// Int64Array returns the values of any integer tag or nil if the tag
+// does not exist.
+func (h *Header) Int64Array(tag int) ([]int64, error) {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil, nil
+	}
+
+	var size int
+	switch e.Type {
+	case typeChar, typeInt8:
+		size = 1
+	case typeInt16:
+		size = 2
+	case typeInt32:
+		size = 4
+	case typeInt64:
+		size = 8
+	default:
+		return nil, fmt.Errorf("tag %d: unexpected type %d for integer", tag, e.Type)
+	}
+
+	b, err := h.data(e, size)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]int64, e.Count)
+	for i := range result {
+		switch size {
+		case 1:
+			result[i] = int64(b[i])
+		case 2:
+			result[i] = int64(binary.BigEndian.Uint16(b[2*i:]))
+		case 4:
+			result[i] = int64(binary.BigEndian.Uint32(b[4*i:]))
+		case 8:
+			result[i] = int64(binary.BigEndian.Uint64(b[8*i:]))
+		}
+	}
+	return result, nil
+}
+
+// Int returns the first value of an integer tag. The boolean is false
+// if the tag does not exist.
+func (h *Header) Int(tag int) (int64, bool, error) {
+	v, err := h.Int64Array(tag)
+	if err != nil || len(v) == 0 {
+		return 0, false, err
+	}
+	return v[0], true, nil
+}
+
+// Bytes returns the value of a binary tag or nil if the tag does not exist.
+func (h *Header) Bytes(tag int) ([]byte, error) {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil, nil
+	}
+	if e.Type != typeBin {
+		return nil, fmt.Errorf("tag %d: unexpected type %d for binary", tag, e.Type)
+	}
+	return h.data(e, 1)
+}
@@ -0,0 +1,375 @@
+package rpm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
+	"github.com/thkukuk/rpm2docserv/pkg/unpack"
+)
+
+const (
+	TagFileDevices = 1095
+	TagFileInodes  = 1096
+)
+
+const (
+	cpioNewcMagic     = "070701"
+	cpioStrippedMagic = "07070X"
+	cpioTrailer       = "TRAILER!!!"
+	// cpioMaxNameSize is the maximal length of a file name
+	// including the NUL byte (PATH_MAX)
+	cpioMaxNameSize = 4096
+)
+
+// PayloadEntry describes the current file of a PayloadReader.
+type PayloadEntry struct {
+	// Name is the absolute path of the file, e.g. "/usr/bin/bash"
+	Name    string
+	Mode    fs.FileMode
+	Size    int64
+	ModTime time.Time
+	Ino     int64
+	Nlink   int
+}
+
+// PayloadReader provides sequential access to the cpio archive
+// inside the RPM payload, similar to archive/tar.
+type PayloadReader struct {
+	r      *bufio.Reader
+	closer func() error
+
+	// file list of the header, needed for stripped cpio archives
+	files []File
+	inode []int64
+	last  map[int64]int
+
+	remaining int64
+	pad       int64
+}
+
+func (r *RPM) decompressor() (io.ReadCloser, error) {
+	compressor, err := r.Header.String(TagPayloadCompressor)
+	if err != nil {
+		return nil, err
+	}
+	if compressor == "" {
+		// old packages don't have the tag, they are always gzip
+		compressor = decompress.Gzip
+	}
+	zr, err := decompress.NewReader(r.r, compressor)
+	if err != nil {
+		return nil, fmt.Errorf("payload: %v", err)
+	}
+	return zr, nil
+}
+
+// Payload returns a reader for the payload. Must be called only once,
+// after the header has been read.
+func (r *RPM) Payload() (*PayloadReader, error) {
+	format, err := r.Header.String(TagPayloadFormat)
+	if err != nil {
+		return nil, err
+	}
+	if format != "" && format != "cpio" {
+		return nil, fmt.Errorf("unsupported payload format %q", format)
+	}
+
+	zr, err := r.decompressor()
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &PayloadReader{
+		r:      bufio.NewReader(zr),
+		closer: zr.Close,
+	}
+
+	if pr.files, err = r.Header.files(); err != nil {
+		return nil, err
+	}
+
+	// For stripped cpio archives we need to know which member of a
+	// set of hardlinks carries the data: it's the last one.
+	inodes, err := r.Header.Int64Array(TagFileInodes)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := r.Header.Int64Array(TagFileDevices)
+	if err != nil {
+		return nil, err
+	}
+	if len(inodes) == len(pr.files) {
+		pr.inode = inodes
+		pr.last = make(map[int64]int)
+		for i, ino := range inodes {
+			key := ino
+			if len(devices) == len(inodes) {
+				key |= devices[i] << 32
+			}
+			pr.inode[i] = key
+			pr.last[key] = i
+		}
+	}
+
+	return pr, nil
+}
+
+// Close releases the resources of the decompressor.
+func (p *PayloadReader) Close() error {
+	return p.closer()
+}
+
+func (p *PayloadReader) skip(n int64) error {
+	_, err := io.CopyN(io.Discard, p.r, n)
+	return err
+}
+
+func (p *PayloadReader) hex(b []byte) (int64, error) {
+	return strconv.ParseInt(string(b), 16, 64)
+}
+
+// Next advances to the next entry of the archive. io.EOF is returned
+// at the end of the archive.
+func (p *PayloadReader) Next() (*PayloadEntry, error) {
+	if err := p.skip(p.remaining + p.pad); err != nil {
+		return nil, err
+	}
+	p.remaining, p.pad = 0, 0
+
+	magic := make([]byte, 6)
+	if _, err := io.ReadFull(p.r, magic); err != nil {
+		return nil, fmt.Errorf("reading cpio header: %v", err)
+	}
+
+	switch string(magic) {
+	case cpioNewcMagic:
+		return p.nextNewc()
+	case cpioStrippedMagic:
+		return p.nextStripped()
+	}
+	return nil, fmt.Errorf("unsupported cpio format %q", magic)
+}
+
+func (p *PayloadReader) nextNewc() (*PayloadEntry, error) {
+	// 13 fields with 8 hex digits each
+	hdr := make([]byte, 13*8)
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		return nil, fmt.Errorf("reading cpio header: %v", err)
+	}
+	var fields [13]int64
+	for i := range fields {
+		v, err := p.hex(hdr[8*i : 8*(i+1)])
+		if err != nil {
+			return nil, fmt.Errorf("bad cpio header: %v", err)
+		}
+		fields[i] = v
+	}
+
+	namesize := fields[11]
+	if namesize < 1 || namesize > cpioMaxNameSize {
+		return nil, fmt.Errorf("bad cpio header: invalid file name size %d", namesize)
+	}
+	name := make([]byte, namesize)
+	if _, err := io.ReadFull(p.r, name); err != nil {
+		return nil, fmt.Errorf("reading cpio file name: %v", err)
+	}
+	// header and name are padded to a multiple of 4 bytes
+	if err := p.skip((4 - (110+namesize)%4) % 4); err != nil {
+		return nil, err
+	}
+
+	e := &PayloadEntry{
+		Name:    strings.TrimSuffix(string(name), "\x00"),
+		Mode:    fileMode(uint32(fields[1])),
+		Ino:     fields[0],
+		Nlink:   int(fields[4]),
+		ModTime: time.Unix(fields[5], 0),
+		Size:    fields[6],
+	}
+	if e.Name == cpioTrailer {
+		return nil, io.EOF
+	}
+	e.Name = path.Clean("/" + strings.TrimPrefix(e.Name, "."))
+
+	p.remaining = e.Size
+	p.pad = (4 - e.Size%4) % 4
+	return e, nil
+}
+
+func (p *PayloadReader) nextStripped() (*PayloadEntry, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		return nil, fmt.Errorf("reading cpio header: %v", err)
+	}
+	idx, err := p.hex(hdr)
+	if err != nil {
+		return nil, fmt.Errorf("bad cpio header: %v", err)
+	}
+	// magic and index are 14 bytes, padded to 16
+	if err := p.skip(2); err != nil {
+		return nil, err
+	}
+
+	if idx == 0xffffffff {
+		// the trailer
+		return nil, io.EOF
+	}
+	if idx < 0 || idx >= int64(len(p.files)) {
+		return nil, fmt.Errorf("file index %d out of range", idx)
+	}
+
+	f := p.files[idx]
+	e := &PayloadEntry{
+		Name:  f.Name,
+		Mode:  f.Mode,
+		Size:  f.Size,
+		Nlink: 1,
+	}
+	if f.Mode&fs.ModeSymlink != 0 {
+		e.Size = int64(len(f.Linkto))
+	} else if !f.Mode.IsRegular() {
+		e.Size = 0
+	}
+	if p.inode != nil {
+		e.Ino = p.inode[idx]
+		if p.last[e.Ino] != int(idx) {
+			// hardlink, data follows with the last member
+			e.Size = 0
+			e.Nlink = 2
+		}
+	}
+
+	p.remaining = e.Size
+	p.pad = (4 - e.Size%4) % 4
+	return e, nil
+}
+
+// Read reads from the current entry of the archive.
+func (p *PayloadReader) Read(b []byte) (int, error) {
+	if p.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.remaining {
+		b = b[:p.remaining]
+	}
+	n, err := p.r.Read(b)
+	p.remaining -= int64(n)
+	if err == io.EOF && p.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (p *PayloadReader) writeSymlink(dst string, e *PayloadEntry) error {
+	b, err := io.ReadAll(p)
+	if err != nil {
+		return err
+	}
+	return unpack.WriteSymlink(dst, e.Name, string(b))
+}
+
+// Extract writes all files of the payload for which want returns
+// true below destDir. Nothing is written outside of destDir, symlinks
+// are converted to relative ones pointing inside destDir.
+// Directories are created as needed, owner and group are not
+// preserved and files are always read- and writable by the user.
+func (r *RPM) Extract(destDir string, want func(name string) bool) error {
+	p, err := r.Payload()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	// names of hardlinks, for which the data did not yet show up
+	pending := make(map[int64][]string)
+
+	for {
+		e, err := p.Next()
+		if err == io.EOF {
+			// hardlinked empty files have no data entry at all
+			for _, names := range pending {
+				for _, name := range names {
+					if !want(name) {
+						continue
+					}
+					dst, err := unpack.SecurePath(destDir, name)
+					if err != nil {
+						return err
+					}
+					if err := unpack.WriteFile(dst, p, 0644, time.Time{}); err != nil {
+						return fmt.Errorf("extracting %s: %v", name, err)
+					}
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case e.Mode.IsRegular():
+			names := []string{e.Name}
+			if e.Nlink > 1 {
+				if e.Size == 0 {
+					pending[e.Ino] = append(pending[e.Ino], e.Name)
+					continue
+				}
+				names = append(pending[e.Ino], e.Name)
+				delete(pending, e.Ino)
+			}
+
+			var first string
+			for _, name := range names {
+				if !want(name) {
+					continue
+				}
+				dst, err := unpack.SecurePath(destDir, name)
+				if err != nil {
+					return err
+				}
+				if first == "" {
+					if err := unpack.WriteFile(dst, p, e.Mode, e.ModTime); err != nil {
+						return fmt.Errorf("extracting %s: %v", name, err)
+					}
+					first = dst
+				} else {
+					if err := unpack.Link(first, dst); err != nil {
+						return fmt.Errorf("extracting %s: %v", name, err)
+					}
+				}
+			}
+		case e.Mode&fs.ModeSymlink != 0:
+			if !want(e.Name) {
+				continue
+			}
+			dst, err := unpack.SecurePath(destDir, e.Name)
+			if err != nil {
+				return err
+			}
+			if err := p.writeSymlink(dst, e); err != nil {
+				return fmt.Errorf("extracting %s: %v", e.Name, err)
+			}
+		case e.Mode.IsDir():
+			if !want(e.Name) {
+				continue
+			}
+			dst, err := unpack.SecurePath(destDir, e.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+		}
+		// Device files, fifos and sockets are never of interest
+	}
+}
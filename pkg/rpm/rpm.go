@@ -3,7 +3,10 @@ package rpm
 import (
 	"bytes"
 	"errors"
-        "os/exec"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"strings"
 )
 
@@ -43,58 +46,282 @@ func SplitRPMname(rpm string) (name string, version string, release string, arch
 	return name, version, release, arch, nil
 }
 
-// Read all relevant tags from the RPM header
-func GetRPMHeader(fullpath string) (name string, version string, release string, arch string, source string, err error) {
-        var out bytes.Buffer
-        var stderr bytes.Buffer
+// EVR returns the version in the notation epoch:version-release as
+// shown by rpm. The epoch is omitted if it is not set or 0.
+func EVR(epoch string, version string, release string) string {
+	evr := version + "-" + release
+	if epoch != "" && epoch != "0" {
+		evr = epoch + ":" + evr
+	}
+	return evr
+}
 
-        cmd := exec.Command("rpm", "-qp", "--qf", "%{NAME},%{VERSION},%{RELEASE},%{ARCH},%{SOURCERPM}", fullpath)
-        cmd.Stdout = &out
-        cmd.Stderr = &stderr
+// File flags, see rpmfiles.h
+const (
+	FileConfig = 1 << 0
+	FileDoc    = 1 << 1
+	FileGhost  = 1 << 6
+)
 
-        if err = cmd.Run(); err != nil {
-                return "", "", "", "", "", err
-        }
+// File is an entry of the file list in the RPM header.
+type File struct {
+	Name   string
+	Mode   fs.FileMode
+	Size   int64
+	Linkto string
+	Flags  uint32
+}
 
-	slice := strings.Split(out.String(), ",")
+// Script is an install or uninstall scriptlet of a RPM.
+type Script struct {
+	// Name is the name of the scriptlet as shown by
+	// "rpm -qp --scripts", e.g. "postinstall".
+	Name        string
+	Interpreter string
+	Body        string
+}
 
-        return slice[0], slice[1], slice[2], slice[3], slice[4], nil
+// Package contains the header tags of a RPM needed by rpm2docserv.
+type Package struct {
+	Name      string
+	Epoch     string
+	Version   string
+	Release   string
+	Arch      string
+	SourceRPM string
+	Files     []File
+	Scripts   []Script
 }
 
-func GetRPMFilelist(rpm string) (list []string, err error) {
+// RPM is an opened RPM file. The lead, the signature header and
+// the main header are read on open, r is positioned at the
+// start of the payload.
+type RPM struct {
+	Signature *Header
+	Header    *Header
 
-        var out bytes.Buffer
-        var stderr bytes.Buffer
+	r io.Reader
+}
+
+// NewReader reads lead, signature and main header from r.
+func NewReader(r io.Reader) (*RPM, error) {
+	lead := make([]byte, leadSize)
+	if _, err := io.ReadFull(r, lead); err != nil {
+		return nil, fmt.Errorf("reading lead: %v", err)
+	}
+	if !bytes.Equal(lead[:4], leadMagic) {
+		return nil, errors.New("no RPM file (bad lead magic)")
+	}
+
+	sig, err := readHeader(r, true)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %v", err)
+	}
+
+	hdr, err := readHeader(r, false)
+	if err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+
+	return &RPM{
+		Signature: sig,
+		Header:    hdr,
+		r:         r,
+	}, nil
+}
+
+// ReadPackage opens the RPM fn and returns the content of its header.
+func ReadPackage(fn string) (*Package, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+
+	pkg, err := r.Header.Package()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return pkg, nil
+}
 
-        err = nil
+// Package decodes the tags of the header into a Package.
+func (h *Header) Package() (*Package, error) {
+	var err error
+	pkg := new(Package)
 
-        cmd := exec.Command("rpm", "-qlp", rpm)
-        cmd.Stdout = &out
-        cmd.Stderr = &stderr
+	for _, t := range []struct {
+		tag int
+		dst *string
+	}{
+		{TagName, &pkg.Name},
+		{TagVersion, &pkg.Version},
+		{TagRelease, &pkg.Release},
+		{TagArch, &pkg.Arch},
+		{TagSourceRPM, &pkg.SourceRPM},
+	} {
+		if *t.dst, err = h.String(t.tag); err != nil {
+			return nil, err
+		}
+	}
 
-        // log.Printf("Executing %s: %v", cmd.Path, cmd.Args)
+	epoch, ok, err := h.Int(TagEpoch)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		pkg.Epoch = fmt.Sprint(epoch)
+	}
 
-        if err = cmd.Run(); err != nil {
-                return nil, err
-        }
+	if pkg.Files, err = h.files(); err != nil {
+		return nil, err
+	}
+	if pkg.Scripts, err = h.scripts(); err != nil {
+		return nil, err
+	}
 
-        return strings.Split(out.String(), "\n"), err
+	return pkg, nil
 }
 
-func GetRPMScripts(rpm string) (list []string, err error) {
+func (h *Header) files() ([]File, error) {
+	names, err := h.StringArray(TagOldFilenames)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		basenames, err := h.StringArray(TagBasenames)
+		if err != nil {
+			return nil, err
+		}
+		dirnames, err := h.StringArray(TagDirnames)
+		if err != nil {
+			return nil, err
+		}
+		dirindexes, err := h.Int64Array(TagDirIndexes)
+		if err != nil {
+			return nil, err
+		}
+		if len(dirindexes) != len(basenames) {
+			return nil, fmt.Errorf("%d basenames, but %d dirindexes", len(basenames), len(dirindexes))
+		}
+		names = make([]string, len(basenames))
+		for i := range basenames {
+			if dirindexes[i] < 0 || dirindexes[i] >= int64(len(dirnames)) {
+				return nil, fmt.Errorf("dirindex %d of %q out of range", dirindexes[i], basenames[i])
+			}
+			names[i] = dirnames[dirindexes[i]] + basenames[i]
+		}
+	}
 
-        var out bytes.Buffer
-        var stderr bytes.Buffer
+	modes, err := h.Int64Array(TagFileModes)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := h.Int64Array(TagLongFileSizes)
+	if err != nil {
+		return nil, err
+	}
+	if sizes == nil {
+		if sizes, err = h.Int64Array(TagFileSizes); err != nil {
+			return nil, err
+		}
+	}
+	linktos, err := h.StringArray(TagFileLinkTos)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := h.Int64Array(TagFileFlags)
+	if err != nil {
+		return nil, err
+	}
 
-        err = nil
+	files := make([]File, len(names))
+	for i := range names {
+		files[i].Name = names[i]
+		if i < len(modes) {
+			files[i].Mode = fileMode(uint32(modes[i]))
+		}
+		if i < len(sizes) {
+			files[i].Size = sizes[i]
+		}
+		if i < len(linktos) {
+			files[i].Linkto = linktos[i]
+		}
+		if i < len(flags) {
+			files[i].Flags = uint32(flags[i])
+		}
+	}
+	return files, nil
+}
 
-        cmd := exec.Command("rpm", "-qp", "--scripts", rpm)
-        cmd.Stdout = &out
-        cmd.Stderr = &stderr
+func (h *Header) scripts() ([]Script, error) {
+	var scripts []Script
 
-        if err = cmd.Run(); err != nil {
-                return nil, err
-        }
+	for _, s := range []struct {
+		name string
+		tag  int
+		prog int
+	}{
+		{"pretrans", TagPreTrans, TagPreTransProg},
+		{"preinstall", TagPreIn, TagPreInProg},
+		{"postinstall", TagPostIn, TagPostInProg},
+		{"preuninstall", TagPreUn, TagPreUnProg},
+		{"postuninstall", TagPostUn, TagPostUnProg},
+		{"posttrans", TagPostTrans, TagPostTransProg},
+	} {
+		if !h.Has(s.tag) && !h.Has(s.prog) {
+			continue
+		}
+		body, err := h.String(s.tag)
+		if err != nil {
+			return nil, err
+		}
+		// The interpreter can be a string or, with arguments,
+		// a string array.
+		prog, err := h.StringArray(s.prog)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, Script{
+			Name:        s.name,
+			Interpreter: strings.Join(prog, " "),
+			Body:        body,
+		})
+	}
+	return scripts, nil
+}
 
-        return strings.Split(out.String(), "\n"), err
+// fileMode converts a unix file mode as stored in the RPM header
+// into a fs.FileMode
+func fileMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0120000:
+		mode |= fs.ModeSymlink
+	case 0020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		mode |= fs.ModeDevice
+	case 0010000:
+		mode |= fs.ModeNamedPipe
+	case 0140000:
+		mode |= fs.ModeSocket
+	}
+	if m&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
 }
@@ -0,0 +1,151 @@
+package rpm
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Tags of the signature header. They use their own number space,
+// e.g. SigTagPGP has the same number as TagRelease.
+const (
+	SigTagDSAHeader = 267
+	SigTagRSAHeader = 268
+	SigTagSize      = 1000
+	SigTagPGP       = 1002
+	SigTagMD5       = 1004
+	SigTagGPG       = 1005
+)
+
+// Hash algorithms of the payload digest, see rpmpgp.h
+var digestAlgos = map[int64]crypto.Hash{
+	1:  crypto.MD5,
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+// ErrUnsigned is returned by Verify if the RPM has no OpenPGP
+// signature at all.
+var ErrUnsigned = errors.New("package is not signed")
+
+// ReadKeyring reads the armored OpenPGP public keys in files, e.g.
+// the files below /usr/lib/rpm/gnupg/keys.
+func ReadKeyring(files ...string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring, nil
+}
+
+// Verify checks the OpenPGP signatures of the signature header with
+// keyring. The header signature has to be valid and the payload has
+// to be covered either by a header+payload signature or by the
+// payload digest of the (signed) main header.
+// The payload is read completely, r cannot be extracted afterwards.
+// The key ID of the signing key is returned.
+func (r *RPM) Verify(keyring openpgp.KeyRing) (uint64, error) {
+	var signer *openpgp.Entity
+
+	check := func(tag int, signed io.Reader) error {
+		sig, err := r.Signature.Bytes(tag)
+		if err != nil {
+			return err
+		}
+		e, err := openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(sig), nil)
+		if err != nil {
+			return fmt.Errorf("signature tag %d: %v", tag, err)
+		}
+		signer = e
+		return nil
+	}
+
+	signed := false
+	for _, tag := range []int{SigTagRSAHeader, SigTagDSAHeader} {
+		if !r.Signature.Has(tag) {
+			continue
+		}
+		if err := check(tag, bytes.NewReader(r.Header.Raw())); err != nil {
+			return 0, err
+		}
+		signed = true
+	}
+
+	// The payload digest is part of the main header, so it is
+	// covered by the header signature.
+	var digest hash.Hash
+	want, err := r.Header.StringArray(TagPayloadDigest)
+	if err != nil {
+		return 0, err
+	}
+	if len(want) > 0 {
+		algo, ok, err := r.Header.Int(TagPayloadDigestAlgo)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			algo = 8
+		}
+		h, ok := digestAlgos[algo]
+		if !ok || !h.Available() {
+			return 0, fmt.Errorf("unsupported payload digest algorithm %d", algo)
+		}
+		digest = h.New()
+		r.r = io.TeeReader(r.r, digest)
+	}
+
+	payloadSigned := false
+	for _, tag := range []int{SigTagPGP, SigTagGPG} {
+		if !r.Signature.Has(tag) {
+			continue
+		}
+		if err := check(tag, io.MultiReader(bytes.NewReader(r.Header.Raw()), r.r)); err != nil {
+			return 0, err
+		}
+		signed = true
+		payloadSigned = true
+		break
+	}
+	if !signed {
+		return 0, ErrUnsigned
+	}
+
+	if digest != nil {
+		// read the rest of the payload, if it was not read for a
+		// header+payload signature
+		if _, err := io.Copy(io.Discard, r.r); err != nil {
+			return 0, fmt.Errorf("reading payload: %v", err)
+		}
+		got := hex.EncodeToString(digest.Sum(nil))
+		if got != want[0] {
+			return 0, fmt.Errorf("payload digest mismatch: got %s, want %s", got, want[0])
+		}
+	} else if !payloadSigned {
+		return 0, errors.New("payload is not covered by a signature or digest")
+	}
+
+	return signer.PrimaryKey.KeyId, nil
+}
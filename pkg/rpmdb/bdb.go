@@ -0,0 +1,171 @@
+package rpmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The bdb backend (Packages) is a Berkeley DB hash database, which is
+// only read here: every hash page is searched for key/data pairs, the
+// data are the header blobs, usually stored on overflow pages. The
+// numbers are in the byte order of the system which wrote the
+// database, the magic of the meta data page tells which one.
+
+const (
+	bdbHashMagic = 0x061561
+
+	bdbMetaSize = 72
+	bdbPageHdr  = 26
+
+	// page types
+	bdbPageHashUnsorted = 2
+	bdbPageOverflow     = 7
+	bdbPageHash         = 13
+
+	// item types on hash pages
+	bdbKeyData = 1
+	bdbOffPage = 3
+
+	bdbMaxBlob = 512 * 1024 * 1024
+)
+
+type bdbDB struct {
+	f        *os.File
+	order    binary.ByteOrder
+	pageSize int
+	lastPage uint32
+}
+
+func readBdb(fn string) ([][]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta := make([]byte, bdbMetaSize)
+	if _, err := f.ReadAt(meta, 0); err != nil {
+		return nil, fmt.Errorf("reading meta data: %v", err)
+	}
+	db := &bdbDB{f: f}
+	switch {
+	case binary.LittleEndian.Uint32(meta[12:16]) == bdbHashMagic:
+		db.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(meta[12:16]) == bdbHashMagic:
+		db.order = binary.BigEndian
+	default:
+		return nil, errors.New("no Berkeley DB hash database")
+	}
+	if meta[24] != 0 {
+		return nil, errors.New("encrypted databases are not supported")
+	}
+	db.pageSize = int(db.order.Uint32(meta[20:24]))
+	if db.pageSize < 512 || db.pageSize > 65536 {
+		return nil, fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+	db.lastPage = db.order.Uint32(meta[32:36])
+
+	var blobs [][]byte
+	for pgno := uint32(1); pgno <= db.lastPage; pgno++ {
+		p, err := db.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if typ := p[25]; typ != bdbPageHash && typ != bdbPageHashUnsorted {
+			continue
+		}
+
+		entries := int(db.order.Uint16(p[20:22]))
+		if bdbPageHdr+2*entries > db.pageSize {
+			return nil, fmt.Errorf("page %d: too many entries", pgno)
+		}
+		inp := func(i int) int {
+			return int(db.order.Uint16(p[bdbPageHdr+2*i:]))
+		}
+		// the items are stored from the end of the page
+		item := func(i int) ([]byte, error) {
+			end := db.pageSize
+			if i > 0 {
+				end = inp(i - 1)
+			}
+			start := inp(i)
+			if start < bdbPageHdr || start >= end || end > db.pageSize {
+				return nil, fmt.Errorf("page %d: invalid item %d", pgno, i)
+			}
+			return p[start:end], nil
+		}
+
+		// key and data alternate
+		for i := 0; i+1 < entries; i += 2 {
+			key, err := item(i)
+			if err != nil {
+				return nil, err
+			}
+			if key[0] == bdbKeyData && len(key) == 5 && db.order.Uint32(key[1:]) == 0 {
+				// record 0 holds the next free instance number
+				continue
+			}
+			data, err := item(i + 1)
+			if err != nil {
+				return nil, err
+			}
+			var blob []byte
+			switch data[0] {
+			case bdbKeyData:
+				blob = append([]byte(nil), data[1:]...)
+			case bdbOffPage:
+				if len(data) < 12 {
+					return nil, fmt.Errorf("page %d: invalid item %d", pgno, i+1)
+				}
+				blob, err = db.overflow(db.order.Uint32(data[4:8]), db.order.Uint32(data[8:12]))
+				if err != nil {
+					return nil, fmt.Errorf("page %d: item %d: %v", pgno, i+1, err)
+				}
+			default:
+				return nil, fmt.Errorf("page %d: unsupported item type %d", pgno, data[0])
+			}
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, nil
+}
+
+func (db *bdbDB) page(n uint32) ([]byte, error) {
+	p := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(p, int64(n)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("reading page %d: %v", n, err)
+	}
+	return p, nil
+}
+
+// overflow reads size bytes from the chain of overflow pages starting
+// at pgno.
+func (db *bdbDB) overflow(pgno uint32, size uint32) ([]byte, error) {
+	if size > bdbMaxBlob {
+		return nil, fmt.Errorf("item too large (%d bytes)", size)
+	}
+	result := make([]byte, 0, size)
+	for pages := uint32(0); len(result) < int(size); pages++ {
+		if pgno == 0 || pgno > db.lastPage || pages > db.lastPage {
+			return nil, errors.New("overflow chain broken")
+		}
+		p, err := db.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if p[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("page %d: no overflow page", pgno)
+		}
+		// the length of the data on the page is stored as the
+		// high free offset
+		n := int(db.order.Uint16(p[22:24]))
+		if bdbPageHdr+n > db.pageSize || len(result)+n > int(size) {
+			return nil, fmt.Errorf("page %d: invalid length", pgno)
+		}
+		result = append(result, p[bdbPageHdr:bdbPageHdr+n]...)
+		pgno = db.order.Uint32(p[16:20])
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package rpmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The ndb backend of rpm (Packages.db, the default of SUSE) is a list
+// of slots pointing to the header blobs. All numbers are little
+// endian. See lib/backend/ndb/rpmpkg.c of rpm.
+
+const (
+	ndbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic   = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+
+	ndbVersion = 0
+
+	ndbPageSize  = 4096
+	ndbBlockSize = 16
+	ndbSlotSize  = 16
+	// the header uses the space of the first two slots
+	ndbHeaderSize = 32
+
+	ndbMaxSlotPages = 4096
+	ndbMaxBlob      = 512 * 1024 * 1024
+)
+
+func readNdb(fn string) ([][]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, ndbHeaderSize)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != ndbHeaderMagic {
+		return nil, errors.New("no ndb database")
+	}
+	if v := binary.LittleEndian.Uint32(hdr[4:8]); v != ndbVersion {
+		return nil, fmt.Errorf("unsupported ndb version %d", v)
+	}
+	npages := binary.LittleEndian.Uint32(hdr[12:16])
+	if npages == 0 || npages > ndbMaxSlotPages {
+		return nil, fmt.Errorf("invalid number of slot pages %d", npages)
+	}
+
+	slots := make([]byte, int(npages)*ndbPageSize-ndbHeaderSize)
+	if _, err := io.ReadFull(f, slots); err != nil {
+		return nil, fmt.Errorf("reading slots: %v", err)
+	}
+
+	var blobs [][]byte
+	for off := 0; off < len(slots); off += ndbSlotSize {
+		slot := slots[off : off+ndbSlotSize]
+		if binary.LittleEndian.Uint32(slot[0:4]) != ndbSlotMagic {
+			return nil, fmt.Errorf("bad magic of slot %d", off/ndbSlotSize)
+		}
+		pkgidx := binary.LittleEndian.Uint32(slot[4:8])
+		if pkgidx == 0 {
+			// unused
+			continue
+		}
+		blob, err := ndbBlob(f, pkgidx, int64(binary.LittleEndian.Uint32(slot[8:12]))*ndbBlockSize)
+		if err != nil {
+			return nil, fmt.Errorf("package %d: %v", pkgidx, err)
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, nil
+}
+
+// ndbBlob reads the blob of package pkgidx at off.
+func ndbBlob(f *os.File, pkgidx uint32, off int64) ([]byte, error) {
+	hdr := make([]byte, 16)
+	if _, err := f.ReadAt(hdr, off); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != ndbBlobMagic {
+		return nil, errors.New("bad blob magic")
+	}
+	if idx := binary.LittleEndian.Uint32(hdr[4:8]); idx != pkgidx {
+		return nil, fmt.Errorf("blob belongs to package %d", idx)
+	}
+	size := binary.LittleEndian.Uint32(hdr[12:16])
+	if size > ndbMaxBlob {
+		return nil, fmt.Errorf("blob too large (%d bytes)", size)
+	}
+	blob := make([]byte, size)
+	if _, err := f.ReadAt(blob, off+int64(len(hdr))); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
@@ -0,0 +1,57 @@
+// Package rpmdb reads the headers of the installed packages from the
+// rpm database of a root directory, without using rpm itself. The
+// sqlite, ndb and (read-only) bdb backends are supported.
+package rpmdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Directories of the rpm database below the root, in the order in
+// which they are searched.
+var dbDirs = []string{
+	"usr/lib/sysimage/rpm",
+	"var/lib/rpm",
+}
+
+type backend struct {
+	name string
+	// file of the database in the database directory
+	file string
+	read func(fn string) ([][]byte, error)
+}
+
+var backends = []backend{
+	{"sqlite", "rpmdb.sqlite", readSqlite},
+	{"ndb", "Packages.db", readNdb},
+	{"bdb", "Packages", readBdb},
+}
+
+// find returns the database file below root and its backend.
+func find(root string) (string, backend, error) {
+	for _, dir := range dbDirs {
+		for _, b := range backends {
+			fn := filepath.Join(root, dir, b.file)
+			if fi, err := os.Stat(fn); err == nil && fi.Mode().IsRegular() {
+				return fn, b, nil
+			}
+		}
+	}
+	return "", backend{}, fmt.Errorf("no rpm database found below %s", root)
+}
+
+// Headers returns the header blobs of all packages in the rpm database
+// below root. They can be parsed with rpm.ParseHeaderBlob.
+func Headers(root string) ([][]byte, error) {
+	fn, b, err := find(root)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := b.read(fn)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %v", fn, b.name, err)
+	}
+	return blobs, nil
+}
@@ -0,0 +1,290 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The sqlite backend stores the headers in the table
+// "Packages (hnum INTEGER PRIMARY KEY, blob BLOB NOT NULL)". Only as
+// much of the SQLite file format as needed to read this table is
+// implemented: table b-trees, records and overflow pages.
+// See https://www.sqlite.org/fileformat.html
+
+const (
+	sqliteMagic = "SQLite format 3\x00"
+
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+
+	// limit for the depth of the b-tree, protects against loops
+	// in broken files
+	sqliteMaxDepth = 32
+
+	sqliteMaxPayload = 512 * 1024 * 1024
+)
+
+type sqliteDB struct {
+	f        *os.File
+	pageSize int
+	// usable size of a page without the reserved bytes at the end
+	usable int
+}
+
+func readSqlite(fn string) ([][]byte, error) {
+	if fi, err := os.Stat(fn + "-wal"); err == nil && fi.Size() > 0 {
+		return nil, errors.New("write-ahead log is not empty, the database is in use or was not closed cleanly")
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, 100)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	if string(hdr[:16]) != sqliteMagic {
+		return nil, errors.New("no SQLite database")
+	}
+	db := &sqliteDB{
+		f:        f,
+		pageSize: int(binary.BigEndian.Uint16(hdr[16:18])),
+	}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int(hdr[20])
+	if db.pageSize < 512 || db.usable < 480 {
+		return nil, fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+
+	// The schema table is the b-tree on page 1, its columns
+	// are type, name, tbl_name, rootpage and sql.
+	var root int64
+	err = db.walk(1, 0, func(rec []any) error {
+		if len(rec) >= 4 && rec[0] == "table" && rec[1] == "Packages" {
+			root, _ = rec[3].(int64)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading schema: %v", err)
+	}
+	if root <= 0 {
+		return nil, errors.New("no Packages table")
+	}
+
+	var blobs [][]byte
+	err = db.walk(uint32(root), 0, func(rec []any) error {
+		for _, col := range rec {
+			if blob, ok := col.([]byte); ok {
+				blobs = append(blobs, blob)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading Packages: %v", err)
+	}
+	return blobs, nil
+}
+
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, errors.New("invalid page number 0")
+	}
+	p := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(p, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("reading page %d: %v", n, err)
+	}
+	return p, nil
+}
+
+// walk calls fn with the decoded record of every row of the table
+// b-tree starting at page pgno.
+func (db *sqliteDB) walk(pgno uint32, depth int, fn func(rec []any) error) error {
+	if depth > sqliteMaxDepth {
+		return errors.New("b-tree too deep")
+	}
+	p, err := db.page(pgno)
+	if err != nil {
+		return err
+	}
+
+	// page 1 starts with the database header
+	off := 0
+	if pgno == 1 {
+		off = 100
+	}
+	typ := p[off]
+	hdrSize := 8
+	if typ == sqliteInteriorTable {
+		hdrSize = 12
+	} else if typ != sqliteLeafTable {
+		return fmt.Errorf("page %d: unexpected page type %#x", pgno, typ)
+	}
+	ncells := int(binary.BigEndian.Uint16(p[off+3:]))
+	if off+hdrSize+2*ncells > db.usable {
+		return fmt.Errorf("page %d: too many cells", pgno)
+	}
+
+	for i := 0; i < ncells; i++ {
+		cell := int(binary.BigEndian.Uint16(p[off+hdrSize+2*i:]))
+		if cell+4 > db.usable {
+			return fmt.Errorf("page %d: cell %d outside of page", pgno, i)
+		}
+
+		if typ == sqliteInteriorTable {
+			if err := db.walk(binary.BigEndian.Uint32(p[cell:]), depth+1, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size, n := sqliteVarint(p[cell:db.usable])
+		_, m := sqliteVarint(p[cell+n : db.usable]) // rowid
+		cell += n + m
+		if n == 0 || m == 0 || size < 0 || size > sqliteMaxPayload {
+			return fmt.Errorf("page %d: invalid cell %d", pgno, i)
+		}
+		payload, err := db.payload(p, cell, int(size))
+		if err != nil {
+			return fmt.Errorf("page %d: cell %d: %v", pgno, i, err)
+		}
+		rec, err := sqliteRecord(payload)
+		if err != nil {
+			return fmt.Errorf("page %d: cell %d: %v", pgno, i, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	if typ == sqliteInteriorTable {
+		return db.walk(binary.BigEndian.Uint32(p[off+8:]), depth+1, fn)
+	}
+	return nil
+}
+
+// payload returns the payload of size bytes of a leaf cell starting
+// at off, following the overflow pages.
+func (db *sqliteDB) payload(p []byte, off int, size int) ([]byte, error) {
+	u := db.usable
+	local := size
+	if maxLocal := u - 35; size > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + (size-minLocal)%(u-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if off+local > u || (local < size && off+local+4 > u) {
+		return nil, errors.New("payload outside of page")
+	}
+
+	result := make([]byte, 0, size)
+	result = append(result, p[off:off+local]...)
+	if local == size {
+		return result, nil
+	}
+
+	next := binary.BigEndian.Uint32(p[off+local:])
+	for pages := 0; len(result) < size; pages++ {
+		if next == 0 || pages > size/(u-4)+1 {
+			return nil, errors.New("overflow chain broken")
+		}
+		op, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(op)
+		n := min(size-len(result), u-4)
+		result = append(result, op[4:4+n]...)
+	}
+	return result, nil
+}
+
+// sqliteVarint decodes a variable length integer. It returns the
+// number of bytes read, 0 if b is too short.
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return int64(v<<8 | uint64(b[i])), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
+
+// sqliteRecord decodes a record into NULL (nil), integer (int64),
+// text (string) and blob ([]byte) values. Floats are not needed and
+// returned as nil.
+func sqliteRecord(rec []byte) ([]any, error) {
+	hdrSize, n := sqliteVarint(rec)
+	if n == 0 || hdrSize < int64(n) || hdrSize > int64(len(rec)) {
+		return nil, errors.New("invalid record header")
+	}
+	hdr := rec[n:hdrSize]
+	body := bytes.NewReader(rec[hdrSize:])
+
+	var values []any
+	for len(hdr) > 0 {
+		typ, n := sqliteVarint(hdr)
+		if n == 0 || typ < 0 {
+			return nil, errors.New("invalid serial type")
+		}
+		hdr = hdr[n:]
+
+		var size int64
+		switch {
+		case typ == 0 || typ == 8 || typ == 9:
+		case typ <= 4:
+			size = typ
+		case typ == 5:
+			size = 6
+		case typ == 6 || typ == 7:
+			size = 8
+		case typ >= 12:
+			size = (typ - 12) / 2
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", typ)
+		}
+		if size > int64(body.Len()) {
+			return nil, errors.New("record truncated")
+		}
+		b := make([]byte, size)
+		body.Read(b)
+
+		switch {
+		case typ == 8:
+			values = append(values, int64(0))
+		case typ == 9:
+			values = append(values, int64(1))
+		case typ >= 1 && typ <= 6:
+			// big-endian two's complement
+			v := int64(int8(b[0]))
+			for _, c := range b[1:] {
+				v = v<<8 | int64(c)
+			}
+			values = append(values, v)
+		case typ >= 12 && typ%2 == 0:
+			values = append(values, b)
+		case typ >= 13:
+			values = append(values, string(b))
+		default:
+			values = append(values, nil)
+		}
+	}
+	return values, nil
+}
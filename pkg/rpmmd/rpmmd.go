@@ -0,0 +1,506 @@
+// Package rpmmd reads the metadata of rpm-md repositories
+// (repodata/repomd.xml, primary.xml and filelists.xml), which
+// allows to find the packages containing specific files without
+// downloading or opening the RPMs.
+package rpmmd
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thkukuk/rpm2docserv/pkg/decompress"
+	"github.com/thkukuk/rpm2docserv/pkg/write"
+)
+
+// File is an entry of the file list of a package.
+type File struct {
+	Name string
+	// Type is "" for regular files and symlinks, "dir" or "ghost"
+	Type string
+}
+
+// Package contains the metadata of a package in the repository.
+type Package struct {
+	Name      string
+	Arch      string
+	Epoch     string
+	Version   string
+	Release   string
+	SourceRPM string
+
+	// Location is the path of the RPM relative to the base of the
+	// repository.
+	Location string
+	// Base is set if the metadata points to the RPM at another
+	// location (xml:base attribute).
+	Base string
+
+	// Checksum of the RPM, ChecksumType is e.g. "sha256".
+	Checksum     string
+	ChecksumType string
+
+	Size int64
+	// Time is the modification time of the RPM in seconds since
+	// the epoch.
+	Time int64
+
+	Files []File
+}
+
+type location struct {
+	Href string `xml:"href,attr"`
+	Base string `xml:"base,attr"`
+}
+
+type checksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type repomdData struct {
+	Type     string   `xml:"type,attr"`
+	Checksum checksum `xml:"checksum"`
+	Location location `xml:"location"`
+}
+
+type repomd struct {
+	Revision string       `xml:"revision"`
+	Data     []repomdData `xml:"data"`
+}
+
+type version struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type primaryPackage struct {
+	Type     string   `xml:"type,attr"`
+	Name     string   `xml:"name"`
+	Arch     string   `xml:"arch"`
+	Version  version  `xml:"version"`
+	Checksum checksum `xml:"checksum"`
+	Time     struct {
+		File int64 `xml:"file,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package int64 `xml:"package,attr"`
+	} `xml:"size"`
+	Location location `xml:"location"`
+	Format   struct {
+		SourceRPM string `xml:"sourcerpm"`
+	} `xml:"format"`
+}
+
+type filelistsFile struct {
+	Type string `xml:"type,attr"`
+	Name string `xml:",chardata"`
+}
+
+type filelistsPackage struct {
+	PkgID string          `xml:"pkgid,attr"`
+	Name  string          `xml:"name,attr"`
+	Arch  string          `xml:"arch,attr"`
+	Files []filelistsFile `xml:"file"`
+}
+
+// Repo is a rpm-md repository.
+type Repo struct {
+	// URL is the base URL of the repository
+	URL string
+	// Revision of the metadata as found in repomd.xml
+	Revision string
+
+	// root is the local directory of the repository, empty
+	// for remote repositories
+	root   string
+	repomd []byte
+	data   map[string]repomdData
+}
+
+// Open reads repodata/repomd.xml of the repository at baseurl,
+// which is either a http(s):// or file:// URL or a local path.
+func Open(baseurl string) (*Repo, error) {
+	r := &Repo{
+		URL:  strings.TrimSuffix(baseurl, "/"),
+		data: make(map[string]repomdData),
+	}
+	if !IsRemote(baseurl) {
+		root, err := localPath(baseurl)
+		if err != nil {
+			return nil, err
+		}
+		r.root = root
+	}
+
+	f, err := r.open("repodata/repomd.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if r.repomd, err = io.ReadAll(f); err != nil {
+		return nil, fmt.Errorf("reading repomd.xml of %s: %v", baseurl, err)
+	}
+
+	var md repomd
+	if err := xml.Unmarshal(r.repomd, &md); err != nil {
+		return nil, fmt.Errorf("parsing repomd.xml of %s: %v", baseurl, err)
+	}
+	r.Revision = md.Revision
+	for _, d := range md.Data {
+		r.data[d.Type] = d
+	}
+	for _, t := range []string{"primary", "filelists"} {
+		if _, ok := r.data[t]; !ok {
+			return nil, fmt.Errorf("repository %s has no %s metadata", baseurl, t)
+		}
+	}
+	return r, nil
+}
+
+// IsRemote returns true if baseurl is a http(s) URL.
+func IsRemote(baseurl string) bool {
+	return strings.HasPrefix(baseurl, "http://") || strings.HasPrefix(baseurl, "https://")
+}
+
+func localPath(baseurl string) (string, error) {
+	if !strings.Contains(baseurl, "://") {
+		return baseurl, nil
+	}
+	u, err := url.Parse(baseurl)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported repository URL %q", baseurl)
+	}
+	return u.Path, nil
+}
+
+// Remote returns true if the repository is accessed via http(s).
+func (r *Repo) Remote() bool {
+	return r.root == ""
+}
+
+// client is used for all downloads. The timeout includes reading the
+// body, it is long enough for large packages, but a stalled server
+// does not block the run forever.
+var client = &http.Client{Timeout: 10 * time.Minute}
+
+func get(url string) (io.ReadCloser, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (r *Repo) open(name string) (io.ReadCloser, error) {
+	if r.Remote() {
+		return get(r.URL + "/" + name)
+	}
+	return os.Open(filepath.Join(r.root, filepath.FromSlash(name)))
+}
+
+// PackagePath returns the local path of the RPM of p. For a mirror
+// of a remote repository (see SaveMetadata and Download), the RPM is
+// always below the mirror directory.
+func (r *Repo) PackagePath(p *Package) (string, error) {
+	if r.Remote() {
+		return "", fmt.Errorf("repository %s is not local", r.URL)
+	}
+	root := r.root
+	if p.Base != "" && !IsRemote(p.Base) {
+		var err error
+		if root, err = localPath(p.Base); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+p.Location))), nil
+}
+
+func newHash(typ string) (hash.Hash, error) {
+	switch typ {
+	case "sha", "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum type %q", typ)
+}
+
+// metadata is an open, decompressed metadata file. The checksum
+// from repomd.xml is verified when closing it.
+type metadata struct {
+	io.Reader
+	f    io.ReadCloser
+	zr   io.ReadCloser
+	h    hash.Hash
+	want string
+	name string
+}
+
+func (r *Repo) openMetadata(typ string) (*metadata, error) {
+	d := r.data[typ]
+	h, err := newHash(d.Checksum.Type)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", d.Location.Href, err)
+	}
+	f, err := r.open(d.Location.Href)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := decompress.NewReader(io.TeeReader(f, h), decompress.FromFilename(d.Location.Href))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", d.Location.Href, err)
+	}
+	return &metadata{
+		Reader: zr,
+		f:      f,
+		zr:     zr,
+		h:      h,
+		want:   strings.TrimSpace(d.Checksum.Value),
+		name:   d.Location.Href,
+	}, nil
+}
+
+func (m *metadata) Close() error {
+	m.zr.Close()
+	defer m.f.Close()
+	// the decompressor does not need to read the whole file
+	if _, err := io.Copy(m.h, m.f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(m.h.Sum(nil)); got != m.want {
+		return fmt.Errorf("%s: checksum mismatch: got %s, want %s", m.name, got, m.want)
+	}
+	return nil
+}
+
+// decodePackages calls fn for every <package> element of the
+// metadata file typ.
+func (r *Repo) decodePackages(typ string, v func() any, fn func(any)) error {
+	m, err := r.openMetadata(typ)
+	if err != nil {
+		return err
+	}
+
+	dec := xml.NewDecoder(m)
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			m.Close()
+			return fmt.Errorf("parsing %s: %v", m.name, err)
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "package" {
+			continue
+		}
+		p := v()
+		if err := dec.DecodeElement(p, &se); err != nil {
+			m.Close()
+			return fmt.Errorf("parsing %s: %v", m.name, err)
+		}
+		fn(p)
+	}
+	return m.Close()
+}
+
+// Packages returns all packages of the repository, for which want
+// returns true. want is called for every package of the repository,
+// with the complete file list.
+func (r *Repo) Packages(want func(p *Package) bool) ([]*Package, error) {
+	primary := make(map[string]*Package)
+	err := r.decodePackages("primary",
+		func() any { return new(primaryPackage) },
+		func(v any) {
+			pp := v.(*primaryPackage)
+			if pp.Type != "" && pp.Type != "rpm" {
+				return
+			}
+			primary[pp.Checksum.Value] = &Package{
+				Name:         pp.Name,
+				Arch:         pp.Arch,
+				Epoch:        pp.Version.Epoch,
+				Version:      pp.Version.Ver,
+				Release:      pp.Version.Rel,
+				SourceRPM:    pp.Format.SourceRPM,
+				Location:     pp.Location.Href,
+				Base:         pp.Location.Base,
+				Checksum:     pp.Checksum.Value,
+				ChecksumType: pp.Checksum.Type,
+				Size:         pp.Size.Package,
+				Time:         pp.Time.File,
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Package
+	err = r.decodePackages("filelists",
+		func() any { return new(filelistsPackage) },
+		func(v any) {
+			fp := v.(*filelistsPackage)
+			p, ok := primary[fp.PkgID]
+			if !ok {
+				return
+			}
+			p.Files = make([]File, 0, len(fp.Files))
+			for _, f := range fp.Files {
+				p.Files = append(p.Files, File{Name: f.Name, Type: f.Type})
+			}
+			if want(p) {
+				result = append(result, p)
+			} else {
+				p.Files = nil
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeFile atomically writes the content of r to dest, if it
+// matches the checksum.
+func writeFile(dest string, r io.Reader, checksumType string, checksum string) error {
+	h, err := newHash(checksumType)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.TrimSpace(checksum) {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, checksum)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
+
+// SaveMetadata stores repomd.xml, primary.xml and filelists.xml in
+// dir/repodata, so that dir can be used as (local) repository later.
+// Other metadata files in dir/repodata are removed.
+func (r *Repo) SaveMetadata(dir string) error {
+	repodata := filepath.Join(dir, "repodata")
+	keep := map[string]bool{"repomd.xml": true}
+
+	for _, t := range []string{"primary", "filelists"} {
+		d := r.data[t]
+		dest := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+d.Location.Href)))
+		keep[filepath.Base(dest)] = true
+		if VerifyFile(dest, d.Checksum.Type, d.Checksum.Value) == nil {
+			continue
+		}
+		f, err := r.open(d.Location.Href)
+		if err != nil {
+			return err
+		}
+		err = writeFile(dest, f, d.Checksum.Type, d.Checksum.Value)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.Location.Href, err)
+		}
+	}
+
+	if entries, err := os.ReadDir(repodata); err == nil {
+		for _, e := range entries {
+			if !keep[e.Name()] {
+				os.Remove(filepath.Join(repodata, e.Name()))
+			}
+		}
+	}
+
+	return write.Atomically(filepath.Join(repodata, "repomd.xml"), false, func(w io.Writer) error {
+		_, err := w.Write(r.repomd)
+		return err
+	})
+}
+
+// VerifyFile checks that the file fn exists and matches the checksum.
+func VerifyFile(fn string, checksumType string, checksum string) error {
+	h, err := newHash(checksumType)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.TrimSpace(checksum) {
+		return fmt.Errorf("%s: checksum mismatch: got %s, want %s", fn, got, checksum)
+	}
+	return nil
+}
+
+// Download fetches the RPM of p into dest and verifies its checksum.
+// dest is only created if the checksum matches.
+func (r *Repo) Download(p *Package, dest string) error {
+	base := r.URL
+	if p.Base != "" {
+		base = strings.TrimSuffix(p.Base, "/")
+	}
+
+	var f io.ReadCloser
+	var err error
+	if IsRemote(base) {
+		f, err = get(base + "/" + p.Location)
+	} else {
+		var fn string
+		if fn, err = r.PackagePath(p); err == nil {
+			f, err = os.Open(fn)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeFile(dest, f, p.ChecksumType, p.Checksum); err != nil {
+		return fmt.Errorf("%s: %v", p.Location, err)
+	}
+	return nil
+}
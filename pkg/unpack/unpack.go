@@ -0,0 +1,108 @@
+// Package unpack contains helpers to safely write the files of a
+// package archive below a destination directory.
+package unpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SecurePath returns the path of name below destDir and makes sure
+// that no already existing parent directory is a symlink, so that
+// nothing can be written outside of destDir.
+func SecurePath(destDir string, name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+
+	dir := destDir
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for _, c := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, c)
+		fi, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s: parent directory %s is no directory", name, dir)
+		}
+	}
+	return filepath.Join(destDir, filepath.FromSlash(name)), nil
+}
+
+// create removes an already existing file dst and creates the
+// parent directories.
+func create(dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(dst); err == nil && !fi.IsDir() {
+		return os.Remove(dst)
+	}
+	return nil
+}
+
+// WriteFile writes the content of r to dst, which must have been
+// returned by SecurePath. The file is always read- and writable by
+// the user.
+func WriteFile(dst string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	if err := create(dst); err != nil {
+		return err
+	}
+	// O_EXCL makes sure we don't follow a symlink
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(dst, modTime, modTime)
+	}
+	return nil
+}
+
+// WriteSymlink creates the symlink dst for the file name of the
+// package, pointing to target.
+func WriteSymlink(dst string, name string, target string) error {
+	// Absolute symlinks would point to the host system and relative
+	// ones could leave destDir with enough "..", so make them
+	// relative to the root of the package.
+	resolved := target
+	if !path.IsAbs(resolved) {
+		resolved = path.Join(path.Dir(name), resolved)
+	}
+	target, err := filepath.Rel(path.Dir(name), path.Clean(resolved))
+	if err != nil {
+		return err
+	}
+
+	if err := create(dst); err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+// Link creates dst as hardlink of the already written file src.
+func Link(src string, dst string) error {
+	if err := create(dst); err != nil {
+		return err
+	}
+	return os.Link(src, dst)
+}